@@ -0,0 +1,143 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"sort"
+	"strings"
+)
+
+// APIDiff is how the exported API of a plugin changes with an update
+type APIDiff struct {
+	Added   []APIChange
+	Removed []APIChange
+	// Changed are the symbols whose signature or definition changed
+	Changed []APIChange
+}
+
+/*
+APIChange is a change to an exported symbol: a function, variable or type, or a method, named Type.Method. Old and New
+are the symbol's kind and signature before and after the change, e.g. func func(x int, y int) int; Old is empty for
+added symbols, New for removed ones.
+*/
+type APIChange struct {
+	Symbol string
+	Old    string
+	New    string
+}
+
+// APIPolicy tells what to do with updates changing the exported API of a plugin
+type APIPolicy int
+
+// API policies
+const (
+	// AllowBreakingChanges activates every update. It is the default.
+	AllowBreakingChanges APIPolicy = iota
+	// RejectBreakingChanges does not activate updates removing or changing exported symbols, nor those whose API, or the
+	// API of the version they replace, is not known
+	RejectBreakingChanges
+)
+
+// BreakingChangeError is sent to error subscribers when an update is rejected by RejectBreakingChanges
+type BreakingChangeError struct {
+	Plugin string
+	Origin string
+	// Diff is nil if the API of the update, or of the version it replaces, is not known
+	Diff *APIDiff
+}
+
+func (e *BreakingChangeError) Error() string {
+
+	if e.Diff == nil {
+		return e.Origin + " may break the API of " + e.Plugin + ": the API of either version is not known"
+	}
+	var changes []string
+	for _, change := range e.Diff.Removed {
+		changes = append(changes, change.Symbol+" removed")
+	}
+	for _, change := range e.Diff.Changed {
+		changes = append(changes, change.Symbol+" changed from "+change.Old+" to "+change.New)
+	}
+	return e.Origin + " breaks the API of " + e.Plugin + ": " + strings.Join(changes, "; ")
+}
+
+// Breaking tells whether the change removes or changes exported symbols, which clients of the plugin may use
+func (d *APIDiff) Breaking() bool {
+	return len(d.Removed) > 0 || len(d.Changed) > 0
+}
+
+// Empty tells whether the exported API is unchanged
+func (d *APIDiff) Empty() bool {
+	return len(d.Added) == 0 && !d.Breaking()
+}
+
+// diffAPI compares the APIs of two versions of a plugin. It returns nil if either is not known.
+func diffAPI(before, after *API) *APIDiff {
+
+	if before == nil || after == nil {
+		return nil
+	}
+	oldSymbols := before.symbols()
+	newSymbols := after.symbols()
+	diff := &APIDiff{}
+	for _, name := range sortedKeys(oldSymbols) {
+		oldSymbol := oldSymbols[name]
+		newSymbol, exists := newSymbols[name]
+		switch {
+		case !exists:
+			diff.Removed = append(diff.Removed, APIChange{Symbol: name, Old: oldSymbol.signature})
+		case newSymbol.key != oldSymbol.key:
+			diff.Changed = append(diff.Changed, APIChange{Symbol: name, Old: oldSymbol.signature, New: newSymbol.signature})
+		}
+	}
+	for _, name := range sortedKeys(newSymbols) {
+		if _, exists := oldSymbols[name]; !exists {
+			diff.Added = append(diff.Added, APIChange{Symbol: name, New: newSymbols[name].signature})
+		}
+	}
+	return diff
+}
+
+// symbol is the kind and signature of an exported symbol, and key what it is compared by
+type symbol struct {
+	signature string
+	key       string
+}
+
+// symbols returns each symbol of the API, methods included
+func (a *API) symbols() map[string]symbol {
+
+	symbols := make(map[string]symbol)
+	add := func(name, kind string, export Export) {
+		key := export.Signature
+		if key == "" {
+			// an API without signatures, like one read by an APIReader or cached by an older version
+			key = export.Type
+		}
+		symbols[name] = symbol{signature: kind + " " + export.Type, key: kind + " " + key}
+	}
+	for _, f := range a.Funcs {
+		add(f.Name, "func", f)
+	}
+	for _, v := range a.Vars {
+		add(v.Name, "var", v)
+	}
+	for _, t := range a.Types {
+		add(t.Name, "type", t)
+		for _, m := range t.Methods {
+			add(t.Name+"."+m.Name, "method", m)
+		}
+	}
+	return symbols
+}
+
+func sortedKeys(m map[string]symbol) []string {
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
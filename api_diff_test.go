@@ -0,0 +1,152 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDiffAPI(t *testing.T) {
+
+	before := &API{
+		Funcs: []Export{{Name: "Add", Type: "func(x int, y int) int"}, {Name: "Sub", Type: "func(x int, y int) int"}},
+		Types: []Export{{Name: "Counter", Type: "struct{n int}", Methods: []Export{{Name: "Inc", Type: "func()"}}}},
+	}
+	after := &API{
+		Funcs: []Export{{Name: "Add", Type: "func(x int, y int, z int) int"}, {Name: "Mul", Type: "func(x int, y int) int"}},
+		Vars:  []Export{{Name: "Sub", Type: "func(x int, y int) int"}},
+		Types: []Export{{Name: "Counter", Type: "struct{n int}"}},
+	}
+
+	diff := diffAPI(before, after)
+	if len(diff.Added) != 1 || diff.Added[0].Symbol != "Mul" || diff.Added[0].Old != "" {
+		t.Fatal("Should be able to detect added symbols, got ", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Symbol != "Counter.Inc" || diff.Removed[0].Old != "method func()" {
+		t.Fatal("Should be able to detect removed methods, got ", diff.Removed)
+	}
+	if len(diff.Changed) != 2 || diff.Changed[0].Symbol != "Add" || diff.Changed[1].Symbol != "Sub" || diff.Changed[1].New != "var func(x int, y int) int" {
+		t.Fatal("Should be able to detect changed signatures and kinds, got ", diff.Changed)
+	}
+	if !diff.Breaking() || diff.Empty() {
+		t.Fatal("Should be able to tell a breaking change")
+	}
+	if diff = diffAPI(before, before); !diff.Empty() {
+		t.Fatal("Should be able to tell an unchanged API")
+	}
+	if diffAPI(before, nil) != nil {
+		t.Fatal("Should not be able to diff an unknown API")
+	}
+}
+
+func TestAPIPolicy(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = copyTestFile(tempPluginDir+"/plugin1.go", testDataDir+"/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir, WithAPIPolicy(RejectBreakingChanges))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	event := nextEvent(t, events)
+	if event.Kind != EventScan || event.Plugins["plugin1"] == nil {
+		t.Fatal("Should be able to receive a scan event")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", "package main\n\nfunc Add(x, y int) int {\n\treturn x + y\n}\n\nfunc Sub(x, y int) int {\n\treturn x - y\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || event.Diff == nil || len(event.Diff.Added) != 1 || event.Diff.Breaking() {
+		t.Fatal("Should be able to send the API diff of a compatible update")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", "package main\n\nfunc Add(x, y, z int) int {\n\treturn x + y + z\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	be, ok := event.Err.(*BreakingChangeError)
+	if event.Kind != EventError || !ok || len(be.Diff.Removed) != 1 || len(be.Diff.Changed) != 1 {
+		t.Fatal("Should be able to reject a breaking update")
+	}
+	if active, _ := pluginator.Get("plugin1"); active != event.Old || len(active.API.Funcs) != 2 {
+		t.Fatal("Should be able to keep the previous version when rejecting a breaking update")
+	}
+
+	addSub := "package main\n\nfunc Add(x, y int) int {\n\treturn x + y\n}\n\nfunc Sub(x, y int) int {\n\treturn x - y\n}\n"
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", addSub+"\ntype Point struct {\n\tx int\n\tY int\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || event.Diff == nil || len(event.Diff.Added) != 1 || event.Diff.Added[0].New != "type struct{Y int}" {
+		t.Fatal("Should be able to leave unexported fields out of the API, got ", event.Kind, event.Err, event.Diff)
+	}
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", addSub+"\ntype Point struct {\n\tY int\n\tz int\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || event.Diff == nil || !event.Diff.Empty() {
+		t.Fatal("Should not be able to break the API by changing unexported fields, got ", event.Kind, event.Err)
+	}
+	renamed := "package main\n\nfunc Add(a, b int) (sum int) {\n\treturn a + b\n}\n\nfunc Sub(x, y int) int {\n\treturn x - y\n}\n"
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", renamed+"\ntype Point struct {\n\tY int\n\tz int\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || event.Diff == nil || !event.Diff.Empty() {
+		t.Fatal("Should not be able to break the API by renaming parameters and results, got ", event.Kind, event.Err)
+	}
+
+	// custom builders do not read the API of plugins
+	err = createTestFile(tempPluginDir+"/greeter.go", "Greeting=hello\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := NewPluginatorF(tempPluginDir, WithAPIPolicy(RejectBreakingChanges), WithBuilder(fakeBuilder{}), WithLoader(fakeLoader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = unknown.Events(context.Background())
+	err = unknown.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unknown.Terminate(context.Background())
+	if event = nextEvent(t, events); event.Kind != EventScan || event.Plugins["greeter"] == nil {
+		t.Fatal("Should be able to add a plugin whose API is not known")
+	}
+	err = replaceTestFile(tempPluginDir+"/greeter.go", "Greeting=ciao\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for event = nextEvent(t, events); event.Name != "greeter"; event = nextEvent(t, events) {
+	}
+	be, ok = event.Err.(*BreakingChangeError)
+	if event.Kind != EventError || !ok || be.Diff != nil {
+		t.Fatal("Should be able to reject an update whose API is not known, got ", event.Kind, event.Err)
+	}
+}
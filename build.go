@@ -0,0 +1,452 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// maxQueuedJobs is how many source changes can wait for delivery before the source is blocked
+const maxQueuedJobs = 64
+
+/*
+buildJob is a source change being processed, or a build in flight shared by the jobs waiting for it. done is closed
+when artifact and api (or err) are ready.
+*/
+type buildJob struct {
+	event    SourceEvent
+	artifact string
+	api      *API
+	err      error
+	done     chan struct{}
+	// cancel makes the job stale: its build is killed, unless other jobs wait for it, and its result ignored
+	ctx    context.Context
+	cancel context.CancelFunc
+	// waiters are the jobs waiting for a build in flight, which is killed when none is left
+	waiters int
+}
+
+// startBuild starts building the plugin changed by event, if any, in the background
+func (p *Pluginator) startBuild(event SourceEvent) *buildJob {
+
+	job := &buildJob{
+		event: event,
+		done:  make(chan struct{}),
+	}
+	job.ctx, job.cancel = context.WithCancel(p.buildCtx)
+	if event.Action == SourceRemove {
+		close(job.done)
+		return job
+	}
+	go func() {
+		job.artifact, job.api, job.err = p.build(job.ctx, event.Plugin)
+		close(job.done)
+	}()
+	return job
+}
+
+/*
+build returns the artifact built from code by the Builder and, if the Builder is an APIReader, the API it exports.
+Concurrent builds of the same code are shared; a shared build is killed once all the jobs waiting for it are stale.
+*/
+func (p *Pluginator) build(ctx context.Context, sp SourcePlugin) (string, *API, error) {
+
+	versionedName := sp.Name + "-" + p.cacheKey(sp)
+
+	p.buildMutex.Lock()
+	inFlight, exists := p.building[versionedName]
+	if !exists {
+		inFlight = &buildJob{
+			done: make(chan struct{}),
+		}
+		inFlight.ctx, inFlight.cancel = context.WithCancel(p.buildCtx)
+		p.building[versionedName] = inFlight
+		go p.runBuild(inFlight, sp, versionedName)
+	}
+	inFlight.waiters++
+	p.buildMutex.Unlock()
+
+	select {
+	case <-inFlight.done:
+		return inFlight.artifact, inFlight.api, inFlight.err
+	case <-ctx.Done():
+		p.buildMutex.Lock()
+		inFlight.waiters--
+		if inFlight.waiters == 0 {
+			inFlight.cancel()
+			// a later build of the same code must not join the killed one
+			if p.building[versionedName] == inFlight {
+				delete(p.building, versionedName)
+			}
+		}
+		p.buildMutex.Unlock()
+		return "", nil, ctx.Err()
+	}
+}
+
+// runBuild runs a build in flight, once a build slot is free
+func (p *Pluginator) runBuild(inFlight *buildJob, sp SourcePlugin, versionedName string) {
+
+	defer func() {
+		p.buildMutex.Lock()
+		if p.building[versionedName] == inFlight {
+			delete(p.building, versionedName)
+		}
+		p.buildMutex.Unlock()
+		inFlight.cancel()
+		close(inFlight.done)
+	}()
+
+	select {
+	case p.buildSlots <- struct{}{}:
+	case <-inFlight.ctx.Done():
+		inFlight.err = inFlight.ctx.Err()
+		return
+	}
+	defer func() {
+		<-p.buildSlots
+	}()
+	inFlight.artifact, inFlight.err = p.builder.Build(inFlight.ctx, sp, versionedName)
+	if apiReader, isAPIReader := p.builder.(APIReader); isAPIReader && inFlight.err == nil {
+		inFlight.api = apiReader.API(inFlight.ctx, sp, versionedName)
+	}
+}
+
+/*
+compile builds a plugin into soFile. Sources and library are written to temporary files first, so that a cache entry is
+never seen half written, by this or other processes sharing the cache. Compiler errors are returned as a *CompileError.
+*/
+func (p *Pluginator) compile(ctx context.Context, sp SourcePlugin, versionedName, soFile string) error {
+
+	buildDir, target := p.buildTarget(sp, versionedName)
+	srcPath := filepath.Join(buildDir, target)
+	var err error
+	switch {
+	case p.runtime == ProcessRuntime:
+		err = p.writeExecutable(ctx, buildDir, sp, versionedName)
+		if sp.Files == nil {
+			srcPath = filepath.Join(buildDir, sp.Name+".go")
+		}
+	case sp.Files == nil:
+		// the go tool derives a unique plugin path from the name and content of the source file
+		err = writeFileAtomic(buildDir+"/"+target, []byte(sp.Code))
+	default:
+		// the plugin path of a package is its import path, made unique by renaming its module (and its imports of itself)
+		err = p.writePackage(ctx, buildDir, sp, "pluginator/"+versionedName)
+	}
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(p.cacheDir, versionedName+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	args := append([]string{"build"}, p.buildArgs(sp)...)
+	args = append(args, "-o", tmpFile.Name(), target)
+	command := p.goCommand(ctx, args...)
+	command.Dir = buildDir
+
+	var stdErr bytes.Buffer
+	command.Stderr = &stdErr
+	_, err = command.Output()
+	if err != nil {
+		if stdErr.Len() == 0 {
+			return err
+		}
+		return newCompileError(sp, buildDir, srcPath, stdErr.String())
+	}
+	return os.Rename(tmpFile.Name(), soFile)
+}
+
+// buildTarget returns the directory the go tool runs in to build a plugin, and what it builds there
+func (p *Pluginator) buildTarget(sp SourcePlugin, versionedName string) (string, string) {
+
+	if sp.Files == nil && p.runtime == InProcessRuntime {
+		return p.cacheDir, versionedName + ".go"
+	}
+	return p.cacheDir + "/" + versionedName, "."
+}
+
+// buildArgs returns the flags of the go tool for building a plugin
+func (p *Pluginator) buildArgs(sp SourcePlugin) []string {
+
+	args := append([]string{}, p.buildFlags...)
+	if _, hasGoMod := sp.Files["go.mod"]; hasGoMod {
+		// go.sum has the sums of the modules pinned to the host's versions: the plugin's requirements are never changed
+		args = append(args, "-mod=readonly")
+	}
+	return args
+}
+
+// cacheKey is the hash identifying a compiled plugin in the cache
+func (p *Pluginator) cacheKey(sp SourcePlugin) string {
+
+	h := sha256.New()
+	parts := []string{p.goVersion, strings.Join(p.buildFlags, " "), strings.Join(p.buildEnv, " "), p.vendorKey, p.hostModules.key(), sp.Name, sp.fingerprint()}
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+/*
+writePackage writes the files of a plugin package into dir. A package without a go.mod gets one declaring module
+modulePath, at the language version of the toolchain; a package with its own go.mod has its module renamed to modulePath, along with its imports of its own
+packages, its dependencies taken from its vendor directory, or the configured one, and the modules it shares with the
+host pinned to the host's versions.
+*/
+func (p *Pluginator) writePackage(ctx context.Context, dir string, sp SourcePlugin, modulePath string) error {
+
+	goMod, hasGoMod := sp.Files["go.mod"]
+	if !hasGoMod {
+		files := copyFiles(sp.Files)
+		files["go.mod"] = "module " + modulePath + "\n"
+		if p.goLang != "" {
+			// without a go directive, the go tool compiles the package as go 1.16
+			files["go.mod"] += "\ngo " + p.goLang + "\n"
+		}
+		return writeTree(dir, files, nil)
+	}
+	files, vendorFiles := splitVendor(sp.Files, p.vendorFiles)
+	if ownPath := modfile.ModulePath([]byte(goMod)); ownPath != "" {
+		rewriteImports(files, ownPath, modulePath)
+	}
+	return writeTree(dir, files, func(tmpDir string) error {
+		return p.prepareModule(ctx, tmpDir, sp, modulePath, vendorFiles)
+	})
+}
+
+/*
+prepareModule renames the module of a plugin package that has its own go.mod, replaces its vendored modules with their
+copies in the cache, and pins the modules it shares with the host, adding their sums to go.sum. Vendoring the modules into dir would not do: a package
+is only shared between plugins if it is compiled from the same path, and dir changes with every version of the plugin.
+*/
+func (p *Pluginator) prepareModule(ctx context.Context, dir string, sp SourcePlugin, modulePath string, vendorFiles map[string]string) error {
+
+	pins, sums, err := p.pin(ctx, dir, sp)
+	if err != nil {
+		return err
+	}
+	args := []string{"mod", "edit", "-module=" + modulePath}
+	if vendorFiles != nil {
+		mirrorDir, err := p.mirrorVendor(vendorFiles)
+		if err != nil {
+			return err
+		}
+		for _, module := range vendoredModules(vendorFiles["modules.txt"]) {
+			args = append(args, "-replace="+module.path+"="+mirrorDir+"/"+module.path)
+		}
+	}
+	// pins come last, so that they override vendored modules
+	args = append(args, pins...)
+	command := p.goCommand(ctx, args...)
+	command.Dir = dir
+	var stdErr bytes.Buffer
+	command.Stderr = &stdErr
+	if err := command.Run(); err != nil {
+		return errors.New("cannot prepare module: " + strings.TrimSpace(stdErr.String()))
+	}
+	return addSums(dir, sums)
+}
+
+/*
+rewriteImports makes the .go files in files import the packages of module from below module to instead. Files that do
+not parse are left to the compiler to report.
+*/
+func rewriteImports(files map[string]string, from, to string) {
+
+	for path, content := range files {
+		if !strings.HasSuffix(path, ".go") {
+			continue
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, path, content, parser.ImportsOnly)
+		if err != nil {
+			continue
+		}
+		// imports are rewritten from the last, so that the offsets of the others stay valid
+		for i := len(file.Imports) - 1; i >= 0; i-- {
+			literal := file.Imports[i].Path
+			importPath, err := strconv.Unquote(literal.Value)
+			if err != nil || importPath != from && !strings.HasPrefix(importPath, from+"/") {
+				continue
+			}
+			start := fset.Position(literal.Pos()).Offset
+			end := fset.Position(literal.End()).Offset
+			content = content[:start] + strconv.Quote(to+strings.TrimPrefix(importPath, from)) + content[end:]
+		}
+		files[path] = content
+	}
+}
+
+/*
+mirrorVendor writes the modules of a vendor directory to the cache, once per content, each with a go.mod so that it can
+replace the vendored module. It returns the directory the modules were written to.
+*/
+func (p *Pluginator) mirrorVendor(vendorFiles map[string]string) (string, error) {
+
+	mirrorDir := p.cacheDir + "/vendor-" + SourcePlugin{Files: vendorFiles}.fingerprint()[:32]
+	if _, err := os.Stat(mirrorDir); err == nil {
+		return mirrorDir, nil
+	}
+	files := copyFiles(vendorFiles)
+	for _, module := range vendoredModules(vendorFiles["modules.txt"]) {
+		if _, exists := files[module.path+"/go.mod"]; !exists {
+			files[module.path+"/go.mod"] = "module " + module.path + "\n\ngo " + module.goVersion + "\n"
+		}
+	}
+	if err := writeTree(mirrorDir, files, nil); err != nil {
+		return "", err
+	}
+	return mirrorDir, nil
+}
+
+type vendoredModule struct {
+	path      string
+	goVersion string
+}
+
+// vendoredModules parses the modules listed in a vendor/modules.txt, e.g. "# example.com/lib v1.0.0"
+func vendoredModules(modulesTxt string) []vendoredModule {
+
+	var modules []vendoredModule
+	for _, line := range strings.Split(modulesTxt, "\n") {
+		if strings.HasPrefix(line, "# ") {
+			if fields := strings.Fields(line); len(fields) >= 3 {
+				modules = append(modules, vendoredModule{path: fields[1], goVersion: "1.16"})
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "## ") || len(modules) == 0 {
+			continue
+		}
+		// annotations of the last module, e.g. "## explicit; go 1.16"
+		for _, annotation := range strings.Split(strings.TrimPrefix(line, "## "), ";") {
+			annotation = strings.TrimSpace(annotation)
+			if strings.HasPrefix(annotation, "go ") {
+				modules[len(modules)-1].goVersion = strings.TrimPrefix(annotation, "go ")
+			}
+		}
+	}
+	return modules
+}
+
+// splitVendor separates the files of a vendor directory from the other files of a plugin module
+func splitVendor(files, vendorFiles map[string]string) (map[string]string, map[string]string) {
+
+	moduleFiles := make(map[string]string, len(files))
+	var ownVendorFiles map[string]string
+	for path, content := range files {
+		if !strings.HasPrefix(path, "vendor/") {
+			moduleFiles[path] = content
+			continue
+		}
+		if ownVendorFiles == nil {
+			ownVendorFiles = make(map[string]string)
+		}
+		ownVendorFiles[strings.TrimPrefix(path, "vendor/")] = content
+	}
+	if ownVendorFiles != nil {
+		return moduleFiles, ownVendorFiles
+	}
+	return moduleFiles, vendorFiles
+}
+
+/*
+writeTree writes files into dir, and calls prepare, if not nil, on them. Files are written to a temporary directory
+first, then renamed, so that dir is never seen half written. An existing dir is left as is.
+*/
+func writeTree(dir string, files map[string]string, prepare func(tmpDir string) error) error {
+
+	if _, err := os.Stat(dir); err == nil {
+		// already written, by a build that failed or was interrupted, or by another process sharing the cache
+		return nil
+	}
+	tmpDir, err := ioutil.TempDir(filepath.Dir(dir), filepath.Base(dir)+".tmp")
+	if err != nil {
+		return err
+	}
+	for path, content := range files {
+		fileName := filepath.Join(tmpDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+		if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+	}
+	if prepare != nil {
+		if err := prepare(tmpDir); err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		os.RemoveAll(tmpDir)
+		if _, statErr := os.Stat(dir); statErr == nil {
+			// written concurrently by another process sharing the cache
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+/*
+goCommand returns a go command running with the configured build environment. The go tool must not switch to another
+toolchain, as a plugin's go.mod could make it do: plugins built by another version would not load.
+*/
+func (p *Pluginator) goCommand(ctx context.Context, args ...string) *exec.Cmd {
+
+	command := exec.CommandContext(ctx, p.goBinary, args...)
+	command.Env = append(os.Environ(), "GOTOOLCHAIN=local")
+	command.Env = append(command.Env, p.buildEnv...)
+	return command
+}
+
+func copyFiles(files map[string]string) map[string]string {
+
+	copied := make(map[string]string, len(files)+1)
+	for path, content := range files {
+		copied[path] = content
+	}
+	return copied
+}
+
+func writeFileAtomic(fileName string, content []byte) error {
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fileName)
+}
@@ -0,0 +1,132 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+package pluginator
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedBuilder builds plugins once the test releases them, recording how many builds run at the same time
+type gatedBuilder struct {
+	started chan string
+	release map[string]chan struct{}
+	mutex   sync.Mutex
+	running int
+	max     int
+}
+
+func newGatedBuilder(names ...string) *gatedBuilder {
+	b := &gatedBuilder{
+		started: make(chan string, len(names)),
+		release: make(map[string]chan struct{}),
+	}
+	for _, name := range names {
+		b.release[name] = make(chan struct{})
+	}
+	return b
+}
+
+func (b *gatedBuilder) Build(ctx context.Context, sp SourcePlugin, versionedName string) (string, error) {
+
+	b.mutex.Lock()
+	b.running++
+	if b.running > b.max {
+		b.max = b.running
+	}
+	b.mutex.Unlock()
+	b.started <- sp.Name
+	<-b.release[sp.Name]
+	b.mutex.Lock()
+	b.running--
+	b.mutex.Unlock()
+	return sp.Code, nil
+}
+
+func (b *gatedBuilder) nextStarted(t *testing.T) string {
+	select {
+	case name := <-b.started:
+		return name
+	case <-time.After(10 * time.Second):
+		t.Fatal("Should be able to start a build")
+	}
+	return ""
+}
+
+func TestMaxParallelBuilds(t *testing.T) {
+
+	pluginator, err := NewPluginator(newFakeSource(), WithMaxParallelBuilds(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cap(pluginator.buildSlots) != 1 {
+		t.Fatal("Should be able to run at least one build")
+	}
+	pluginator.Terminate(context.Background())
+	pluginator, err = NewPluginator(newFakeSource(), WithMaxParallelBuilds(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cap(pluginator.buildSlots) != 2 {
+		t.Fatal("Should be able to set the number of parallel builds")
+	}
+	pluginator.Terminate(context.Background())
+}
+
+func TestWorkerPool(t *testing.T) {
+
+	names := []string{"a", "b", "c"}
+	builder := newGatedBuilder(names...)
+	source := newFakeSource()
+	pluginator, err := NewPluginator(source, WithMaxParallelBuilds(2), WithQuietPeriod(0), WithBuilder(builder),
+		WithLoader(fakeLoader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	if event.Kind != EventScan {
+		t.Fatal("Should be able to receive a scan event")
+	}
+
+	for _, name := range names {
+		source.changes <- SourceEvent{Action: SourceAdd, Plugin: SourcePlugin{Name: name, Code: "Name=" + name + "\n"}}
+	}
+	started := []string{builder.nextStarted(t), builder.nextStarted(t)}
+	select {
+	case name := <-builder.started:
+		t.Fatal("Should not be able to run more builds than build slots, started ", name)
+	case <-time.After(200 * time.Millisecond):
+	}
+	// the builds finish in reverse name order
+	sort.Strings(started)
+	close(builder.release[started[1]])
+	third := builder.nextStarted(t)
+	close(builder.release[third])
+	close(builder.release[started[0]])
+
+	for _, name := range names {
+		event = nextEvent(t, events)
+		if event.Kind != EventAdd || event.Name != name {
+			t.Fatal("Should be able to deliver builds in the order of changes, got ", event.Name, " instead of ", name)
+		}
+	}
+	builder.mutex.Lock()
+	max := builder.max
+	builder.mutex.Unlock()
+	if max != 2 {
+		t.Fatal("Should be able to run builds in parallel up to the number of build slots, ran ", max)
+	}
+
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
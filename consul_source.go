@@ -0,0 +1,127 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type consulSource struct {
+	host      string
+	port      int
+	prefix    string
+	watcher   *consulWatcher
+	changes   chan SourceEvent
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewConsulSource returns a Source watching the subkeys of keyPrefix on the host:port consul instance. Subkeys must be
+// named keyPrefix.pluginname.go. Changes are picked up with blocking queries; pollInterval is the fallback used after
+// errors or against agents not supporting them (DefaultConsulPollInterval if <= 0).
+func NewConsulSource(host string, port int, keyPrefix string, pollInterval time.Duration) (Source, error) {
+
+	cw, err := newConsulWatcher(host, port, keyPrefix, pollInterval)
+	if err != nil {
+		return nil, err
+	}
+	cs := &consulSource{
+		host:    host,
+		port:    port,
+		prefix:  keyPrefix,
+		watcher: cw,
+		changes: make(chan SourceEvent),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go cs.watch()
+	return cs, nil
+}
+
+func (cs *consulSource) String() string {
+	return cs.host + ":" + strconv.Itoa(cs.port) + ":" + cs.prefix
+}
+
+func (cs *consulSource) List() ([]SourcePlugin, error) {
+
+	kvList, _, err := cs.watcher.KVClient.List(cs.prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	var plugins []SourcePlugin
+	for _, kvPair := range kvList {
+		name, ok := cs.pluginName(kvPair.Key)
+		if !ok {
+			continue
+		}
+		plugins = append(plugins, SourcePlugin{
+			Name:   name,
+			Code:   string(kvPair.Value),
+			Origin: kvPair.Key,
+		})
+	}
+	return plugins, nil
+}
+
+func (cs *consulSource) Changes() <-chan SourceEvent {
+	return cs.changes
+}
+
+// Close stops the consul watcher, and waits for it to exit
+func (cs *consulSource) Close() error {
+
+	cs.closeOnce.Do(func() {
+		close(cs.closing)
+		cs.watcher.Terminate()
+	})
+	<-cs.done
+	return nil
+}
+
+func (cs *consulSource) watch() {
+
+	defer close(cs.done)
+	defer close(cs.changes)
+	for event := range cs.watcher.Events {
+		name, ok := cs.pluginName(event.Key)
+		if !ok {
+			continue
+		}
+		se := SourceEvent{
+			Plugin: SourcePlugin{
+				Name:   name,
+				Code:   event.Value,
+				Origin: event.Key,
+			},
+		}
+		switch event.Action {
+		case consulAddAction:
+			se.Action = SourceAdd
+		case consulUpdateAction:
+			se.Action = SourceUpdate
+		case consulRemoveAction:
+			se.Action = SourceRemove
+			se.Plugin.Code = ""
+		}
+		select {
+		case cs.changes <- se:
+		case <-cs.closing:
+		}
+	}
+}
+
+func (cs *consulSource) pluginName(key string) (string, bool) {
+
+	if !strings.HasSuffix(key, ".go") {
+		log.Println("Bad plugin name must end in .go: ", key)
+		return "", false
+	}
+	key = strings.TrimPrefix(key, cs.prefix+".")
+	return strings.TrimSuffix(key, ".go"), true
+}
@@ -4,6 +4,7 @@
 package pluginator
 
 import (
+	"context"
 	"log"
 	"strconv"
 	"time"
@@ -11,12 +12,21 @@ import (
 	"github.com/hashicorp/consul/api"
 )
 
+const (
+	// consulWaitTime is the longest a blocking query is held by the consul agent
+	consulWaitTime = 5 * time.Minute
+	// DefaultConsulPollInterval is the fallback poll interval used by consul sources
+	DefaultConsulPollInterval = 3 * time.Second
+)
+
 type consulWatcher struct {
-	prefix    string
-	Events    chan consulEvent
-	KVClient  *api.KV
-	kvS       map[string]*valueAndModified
-	terminate bool
+	prefix       string
+	Events       chan consulEvent
+	KVClient     *api.KV
+	kvS          map[string]*valueAndModified
+	pollInterval time.Duration
+	ctx          context.Context
+	cancel       context.CancelFunc
 }
 
 type consulEvent struct {
@@ -38,7 +48,11 @@ type valueAndModified struct {
 	Modified uint64
 }
 
-func newConsulWatcher(host string, port int, keyPrefix string) (*consulWatcher, error) {
+/*
+newConsulWatcher watches keyPrefix with blocking queries, so that changes are seen as soon as consul commits them.
+pollInterval is only used when blocking is not possible: after errors, and against agents that do not return an index.
+*/
+func newConsulWatcher(host string, port int, keyPrefix string, pollInterval time.Duration) (*consulWatcher, error) {
 
 	cw := consulWatcher{}
 
@@ -51,33 +65,71 @@ func newConsulWatcher(host string, port int, keyPrefix string) (*consulWatcher,
 	}
 	kv := client.KV()
 
+	if pollInterval <= 0 {
+		pollInterval = DefaultConsulPollInterval
+	}
+
 	cw.prefix = keyPrefix
 	cw.Events = make(chan consulEvent)
 	cw.KVClient = kv
 	cw.kvS = make(map[string]*valueAndModified)
+	cw.pollInterval = pollInterval
+	cw.ctx, cw.cancel = context.WithCancel(context.Background())
 
-	go func() {
-		for !cw.terminate {
-			time.Sleep(3 * time.Second)
-			cw.scan()
-		}
-	}()
+	go cw.watch()
 
 	return &cw, nil
 }
 
 func (cw *consulWatcher) Terminate() {
-	cw.terminate = true
+	cw.cancel()
 	log.Println("Terminating consul watcher...")
 }
 
-func (cw *consulWatcher) scan() {
+func (cw *consulWatcher) watch() {
 
-	kvList, _, err := cw.KVClient.List(cw.prefix, nil)
-	if err != nil {
-		log.Println(err)
-		return
+	defer close(cw.Events)
+	var index uint64
+	for cw.ctx.Err() == nil {
+		options := &api.QueryOptions{
+			WaitIndex: index,
+			WaitTime:  consulWaitTime,
+		}
+		kvList, meta, err := cw.KVClient.List(cw.prefix, options.WithContext(cw.ctx))
+		if err != nil {
+			if cw.ctx.Err() != nil {
+				return
+			}
+			log.Println(err)
+			cw.sleep()
+			continue
+		}
+		switch {
+		case meta.LastIndex == 0:
+			// no blocking support, fall back to polling
+			cw.sleep()
+		case meta.LastIndex < index:
+			// the index went backwards (e.g. a snapshot restore), start over as recommended by consul
+			index = 0
+		default:
+			index = meta.LastIndex
+		}
+		if !cw.diff(kvList) {
+			return
+		}
 	}
+}
+
+func (cw *consulWatcher) sleep() {
+	select {
+	case <-time.After(cw.pollInterval):
+	case <-cw.ctx.Done():
+	}
+}
+
+// diff compares kvList with the last known state and sends the differences. It returns false if the watcher was terminated.
+func (cw *consulWatcher) diff(kvList api.KVPairs) bool {
+
 	for _, kvPair := range kvList {
 		if vM, exists := cw.kvS[kvPair.Key]; !exists {
 			vM := valueAndModified{
@@ -90,7 +142,9 @@ func (cw *consulWatcher) scan() {
 				Key:    kvPair.Key,
 				Value:  string(kvPair.Value),
 			}
-			cw.Events <- event
+			if !cw.send(event) {
+				return false
+			}
 		} else {
 			if kvPair.ModifyIndex > vM.Modified {
 				vM := valueAndModified{
@@ -103,7 +157,9 @@ func (cw *consulWatcher) scan() {
 					Key:    kvPair.Key,
 					Value:  string(kvPair.Value),
 				}
-				cw.Events <- event
+				if !cw.send(event) {
+					return false
+				}
 			}
 		}
 	}
@@ -114,10 +170,22 @@ func (cw *consulWatcher) scan() {
 				Key:    k,
 				Value:  vm.Value,
 			}
-			cw.Events <- event
 			delete(cw.kvS, k)
+			if !cw.send(event) {
+				return false
+			}
 		}
 	}
+	return true
+}
+
+func (cw *consulWatcher) send(event consulEvent) bool {
+	select {
+	case cw.Events <- event:
+		return true
+	case <-cw.ctx.Done():
+		return false
+	}
 }
 
 func contains(slice []*api.KVPair, key string) bool {
@@ -3,9 +3,18 @@
 package pluginator
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -30,7 +39,7 @@ func TestConsulWatcher(t *testing.T) {
 
 	uuid := uuid.New().String()
 
-	cw, err := newConsulWatcher("localhost", 8500, uuid)
+	cw, err := newConsulWatcher("localhost", 8500, uuid, DefaultConsulPollInterval)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -321,6 +330,132 @@ func TestConsulMode(t *testing.T) {
 			t.Fatal("Should be able to invoke loaded function")
 		}
 	}
-	pluginator.Terminate()
+	pluginator.Terminate(context.Background())
 
 }
+
+// fakeKV is a stand-in for the consul KV endpoint, supporting recursive blocking queries
+type fakeKV struct {
+	mu       sync.Mutex
+	changed  *sync.Cond
+	index    uint64
+	pairs    map[string]*api.KVPair
+	blocking int
+}
+
+func newFakeKV() *fakeKV {
+	f := &fakeKV{
+		index: 1,
+		pairs: make(map[string]*api.KVPair),
+	}
+	f.changed = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *fakeKV) put(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	f.pairs[key] = &api.KVPair{Key: key, Value: []byte(value), ModifyIndex: f.index}
+	f.changed.Broadcast()
+}
+
+func (f *fakeKV) delete(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	delete(f.pairs, key)
+	f.changed.Broadcast()
+}
+
+func (f *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	wait, err := time.ParseDuration(r.URL.Query().Get("wait"))
+	if err != nil {
+		wait = 5 * time.Minute
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if waitIndex > 0 {
+		f.blocking++
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		go func() {
+			<-ctx.Done()
+			f.mu.Lock()
+			f.changed.Broadcast()
+			f.mu.Unlock()
+		}()
+		for f.index <= waitIndex && ctx.Err() == nil {
+			f.changed.Wait()
+		}
+		cancel()
+	}
+
+	var pairs api.KVPairs
+	for key, pair := range f.pairs {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, pair)
+		}
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	if len(pairs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(pairs)
+}
+
+func TestConsulWatcherBlocking(t *testing.T) {
+
+	kv := newFakeKV()
+	server := httptest.NewServer(kv)
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, portS, err := net.SplitHostPort(serverURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a poll interval this long would make the test time out if changes were polled for
+	cw, err := newConsulWatcher(host, port, "plugins", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cw.Terminate()
+
+	expect := func(action consulAction, key, value string) {
+		select {
+		case event := <-cw.Events:
+			if event.Action != action || event.Key != key || event.Value != value {
+				t.Fatalf("Should be able to detect a change, got %v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Should be notified of a change without polling")
+		}
+	}
+
+	kv.put("plugins.key1", "key 1 bytes")
+	expect(consulAddAction, "plugins.key1", "key 1 bytes")
+	kv.put("plugins.key1", "key 1 bytes-updated")
+	expect(consulUpdateAction, "plugins.key1", "key 1 bytes-updated")
+	kv.put("other.key2", "key 2 bytes")
+	kv.delete("plugins.key1")
+	expect(consulRemoveAction, "plugins.key1", "key 1 bytes-updated")
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.blocking == 0 {
+		t.Fatal("Should use blocking queries")
+	}
+}
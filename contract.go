@@ -0,0 +1,105 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+Contract is what plugins must export to be activated, by symbol name. The expected type of a symbol is given as a
+reflect.Type, or as a value of that type: e.g. (func(int) int)(nil) for a function, "" for a string variable, or a nil
+pointer to an interface, like (*fmt.Stringer)(nil), for any type implementing the interface. A nil type only requires the
+symbol to exist. Variables, which plugins export as pointers, match the type of the variable.
+*/
+type Contract struct {
+	// Required symbols must be exported, with their type
+	Required map[string]interface{}
+	// Optional symbols may be missing, but must have their type if exported
+	Optional map[string]interface{}
+}
+
+// ContractError is sent to error subscribers when a plugin does not satisfy the contract. The plugin is not activated.
+type ContractError struct {
+	Plugin string
+	Origin string
+	// Violations are sorted by symbol
+	Violations []*SymbolError
+}
+
+func (e *ContractError) Error() string {
+
+	lines := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		lines[i] = violation.Error()
+	}
+	return e.Origin + " does not satisfy the contract: " + strings.Join(lines, "; ")
+}
+
+// check returns a *ContractError if the plugin loaded from sp does not satisfy the contract
+func (c *Contract) check(sp SourcePlugin, pluginLib Symbols) error {
+
+	var violations []*SymbolError
+	checkSymbols := func(symbols map[string]interface{}, required bool) {
+		for symbol, expected := range symbols {
+			want := expectedType(expected)
+			sym, err := pluginLib.Lookup(symbol)
+			if err != nil {
+				if required {
+					violations = append(violations, &SymbolError{Plugin: sp.Name, Symbol: symbol, Want: want})
+				}
+				continue
+			}
+			if want != nil && !matchesType(reflect.TypeOf(sym), want) {
+				violations = append(violations, &SymbolError{Plugin: sp.Name, Symbol: symbol, Type: reflect.TypeOf(sym), Want: want})
+			}
+		}
+	}
+	checkSymbols(c.Required, true)
+	checkSymbols(c.Optional, false)
+	if len(violations) == 0 {
+		return nil
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		return violations[i].Symbol < violations[j].Symbol
+	})
+	return &ContractError{
+		Plugin:     sp.Name,
+		Origin:     sp.Origin,
+		Violations: violations,
+	}
+}
+
+// expectedType returns the type a contract expects for a symbol, nil for any type
+func expectedType(expected interface{}) reflect.Type {
+
+	if expected == nil {
+		return nil
+	}
+	if t, ok := expected.(reflect.Type); ok {
+		return t
+	}
+	t := reflect.TypeOf(expected)
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Interface {
+		return t.Elem()
+	}
+	return t
+}
+
+// matchesType tells whether a symbol of type symType, or the variable it points to, is a want
+func matchesType(symType, want reflect.Type) bool {
+
+	types := []reflect.Type{symType}
+	if symType.Kind() == reflect.Ptr {
+		types = append(types, symType.Elem())
+	}
+	for _, t := range types {
+		if t == want || want.Kind() == reflect.Interface && t.Implements(want) {
+			return true
+		}
+	}
+	return false
+}
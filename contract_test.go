@@ -0,0 +1,85 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestContract(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = copyTestFile(tempPluginDir+"/plugin1.go", testDataDir+"/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = copyTestFile(tempPluginDir+"/plugin3.go", testDataDir+"/plugin3.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir, WithContract(Contract{
+		Required: map[string]interface{}{
+			"Add": reflect.TypeOf(func(int, int) int { return 0 }),
+		},
+		Optional: map[string]interface{}{
+			"Name":     (*fmt.Stringer)(nil),
+			"Priority": 0,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	// errors come first, as plugins are activated in order and the scan event is sent last
+	event := nextEvent(t, events)
+	ce, ok := event.Err.(*ContractError)
+	if event.Kind != EventError || event.Name != "plugin3" || !ok || len(ce.Violations) != 1 || ce.Violations[0].Symbol != "Add" {
+		t.Fatal("Should be able to reject a plugin missing a required symbol")
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventScan || len(event.Plugins) != 1 || event.Plugins["plugin1"] == nil {
+		t.Fatal("Should be able to activate a plugin satisfying the contract")
+	}
+
+	code := "package main\n\nimport \"strconv\"\n\ntype name int\n\nfunc (n name) String() string {\n\treturn strconv.Itoa(int(n))\n}\n\n" +
+		"var Name name\n\nvar Priority = 1\n\nfunc Add(x, y int) int {\n\treturn x + y\n}\n"
+	err = createTestFile(tempPluginDir+"/plugin4.go", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventAdd || event.Name != "plugin4" {
+		t.Fatal("Should be able to match optional variables and interfaces, got ", event.Err)
+	}
+
+	err = replaceTestFile(tempPluginDir+"/plugin4.go", "package main\n\nvar Priority = \"high\"\n\nfunc Add(x, y int) int {\n\treturn x + y\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	ce, ok = event.Err.(*ContractError)
+	if event.Kind != EventError || !ok || len(ce.Violations) != 1 || ce.Violations[0].Type.String() != "*string" {
+		t.Fatal("Should be able to reject an optional symbol of the wrong type")
+	}
+	if event.Old == nil || pluginator.LastFailure("plugin4") == nil {
+		t.Fatal("Should be able to keep the previous version of a plugin violating the contract")
+	}
+}
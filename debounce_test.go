@@ -0,0 +1,193 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuietPeriod(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	pluginator, err := NewPluginatorF(tempPluginDir, WithQuietPeriod(500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	if event.Kind != EventScan {
+		t.Fatal("Should be able to receive a scan event")
+	}
+
+	// an editor saving a file in several writes
+	code := "package main\n\nvar Saved = true\n"
+	err = ioutil.WriteFile(tempPluginDir+"/saved.go", []byte(code[:10]), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, end := range []int{20, 30, len(code)} {
+		time.Sleep(50 * time.Millisecond)
+		err = ioutil.WriteFile(tempPluginDir+"/saved.go", []byte(code[:end]), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventAdd || event.New.Code != code {
+		t.Fatal("Should be able to build a burst of changes once, got ", event.Kind, event.Err)
+	}
+	select {
+	case event = <-events:
+		t.Fatal("Should not be able to build the intermediate changes of a burst, got ", event.Kind)
+	case <-time.After(time.Second):
+	}
+
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// blockingBuilder builds code containing "slow" only once its build is cancelled, reporting the cancellation
+type blockingBuilder struct {
+	cancelled chan string
+}
+
+func (b blockingBuilder) Build(ctx context.Context, sp SourcePlugin, versionedName string) (string, error) {
+
+	if strings.Contains(sp.Code, "slow") {
+		<-ctx.Done()
+		b.cancelled <- sp.Code
+		return "", ctx.Err()
+	}
+	return sp.Code, nil
+}
+
+func TestStaleBuilds(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	builder := blockingBuilder{cancelled: make(chan string, 1)}
+	pluginator, err := NewPluginatorF(tempPluginDir, WithQuietPeriod(0), WithBuilder(builder), WithLoader(fakeLoader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	if event.Kind != EventScan {
+		t.Fatal("Should be able to receive a scan event")
+	}
+
+	err = createTestFile(tempPluginDir+"/changing.go", "Speed=slow\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	err = replaceTestFile(tempPluginDir+"/changing.go", "Speed=fast\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case code := <-builder.cancelled:
+		if code != "Speed=slow\n" {
+			t.Fatal("Should be able to cancel the stale build")
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("Should be able to cancel a build made stale by a new change")
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventAdd || event.New.Code != "Speed=fast\n" {
+		t.Fatal("Should be able to skip a stale build, got ", event.Kind, event.Err)
+	}
+
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClosedSource(t *testing.T) {
+
+	source := newFakeSource()
+	pluginator, err := NewPluginator(source, WithQuietPeriod(time.Hour), WithBuilder(fakeBuilder{}), WithLoader(fakeLoader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	if event.Kind != EventScan {
+		t.Fatal("Should be able to receive a scan event")
+	}
+
+	source.changes <- SourceEvent{Action: SourceAdd, Plugin: SourcePlugin{Name: "greeter", Code: "Greeting=hello\n"}}
+	source.Close()
+	event = nextEvent(t, events)
+	if event.Kind != EventAdd || event.Name != "greeter" {
+		t.Fatal("Should be able to build the pending changes of a closed source")
+	}
+
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTerminatePending(t *testing.T) {
+
+	source := newFakeSource()
+	builder := blockingBuilder{cancelled: make(chan string, 1)}
+	pluginator, err := NewPluginator(source, WithQuietPeriod(time.Hour), WithBuilder(builder), WithLoader(fakeLoader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	if event.Kind != EventScan {
+		t.Fatal("Should be able to receive a scan event")
+	}
+
+	source.changes <- SourceEvent{Action: SourceAdd, Plugin: SourcePlugin{Name: "changing", Code: "Speed=slow\n"}}
+	terminated := make(chan error, 1)
+	go func() {
+		terminated <- pluginator.Terminate(context.Background())
+	}()
+	select {
+	case err = <-terminated:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Should not be able to build the pending changes of a terminated Pluginator")
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/*
+dirSource watches a directory. Its top-level .go files are single-file plugins, and each of its subdirectories is a
+plugin package, named after the subdirectory, made of all the files in it.
+*/
+type dirSource struct {
+	dir       string
+	watcher   *fsnotify.Watcher
+	changes   chan SourceEvent
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	// dirPlugins are the subdirectories that were sent as plugins
+	dirMutex   sync.Mutex
+	dirPlugins map[string]bool
+}
+
+// NewDirSource returns a Source watching the .go files and the subdirectories in directory dir
+func NewDirSource(dir string) (Source, error) {
+
+	if strings.HasSuffix(dir, "/") {
+		return nil, errors.New("Plugin dir must not end with /")
+	}
+
+	f, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !f.Mode().IsDir() {
+		return nil, errors.New(dir + " is not a directory")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &dirSource{
+		dir:        dir,
+		watcher:    watcher,
+		changes:    make(chan SourceEvent),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+		dirPlugins: make(map[string]bool),
+	}
+	err = ds.addWatches(dir)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go ds.watch()
+	return ds, nil
+}
+
+func (ds *dirSource) String() string {
+	return ds.dir
+}
+
+func (ds *dirSource) List() ([]SourcePlugin, error) {
+
+	files, err := ioutil.ReadDir(ds.dir)
+	if err != nil {
+		return nil, err
+	}
+	var plugins []SourcePlugin
+	for _, file := range files {
+		var sp SourcePlugin
+		switch {
+		case isCompileUnit(file):
+			sp, err = ds.readPlugin(file.Name())
+		case isPluginDir(file):
+			var hasGoFiles bool
+			sp, hasGoFiles, err = ds.readDirPlugin(file.Name())
+			if err == nil && !hasGoFiles {
+				continue
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		plugins = append(plugins, sp)
+	}
+	return plugins, nil
+}
+
+func (ds *dirSource) Changes() <-chan SourceEvent {
+	return ds.changes
+}
+
+// Close stops watching the directory, and waits for the watch to exit
+func (ds *dirSource) Close() error {
+
+	var err error
+	ds.closeOnce.Do(func() {
+		close(ds.closing)
+		err = ds.watcher.Close()
+	})
+	<-ds.done
+	return err
+}
+
+// addWatches watches dir and all the directories below it
+func (ds *dirSource) addWatches(dir string) error {
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		return ds.watcher.Add(path)
+	})
+}
+
+func (ds *dirSource) watch() {
+
+	defer close(ds.done)
+	defer close(ds.changes)
+	for {
+		select {
+		case event, ok := <-ds.watcher.Events:
+			if !ok {
+				return
+			}
+			rel, err := filepath.Rel(ds.dir, event.Name)
+			if err != nil || rel == "." {
+				break
+			}
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+					if err := ds.addWatches(event.Name); err != nil {
+						log.Println(err)
+					}
+				}
+			}
+			if parts := strings.SplitN(rel, string(filepath.Separator), 2); len(parts) == 2 {
+				// a change inside a plugin package
+				ds.notifyDir(SourceUpdate, parts[0])
+				break
+			}
+			switch {
+			case event.Op&fsnotify.Create != 0:
+				ds.notify(SourceAdd, event.Name)
+			case event.Op&fsnotify.Write != 0:
+				ds.notify(SourceUpdate, event.Name)
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				name := filepath.Base(event.Name)
+				switch {
+				case strings.HasSuffix(name, ".go"):
+					ds.send(SourceEvent{
+						Action: SourceRemove,
+						Plugin: SourcePlugin{Name: strings.TrimSuffix(name, ".go")},
+					})
+				case ds.isDirPlugin(name):
+					ds.removeDir(name)
+				}
+			}
+		case err, ok := <-ds.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Println("error:", err)
+		}
+	}
+}
+
+// notify sends a change to a top-level file or directory
+func (ds *dirSource) notify(action SourceAction, fileName string) {
+
+	fileInfo, err := os.Lstat(fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return
+	}
+	if isPluginDir(fileInfo) {
+		ds.notifyDir(action, fileInfo.Name())
+		return
+	}
+	if !isCompileUnit(fileInfo) {
+		return
+	}
+	sp, err := ds.readPlugin(fileInfo.Name())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ds.send(SourceEvent{
+		Action: action,
+		Plugin: sp,
+	})
+}
+
+// notifyDir sends a change to plugin package dirName. A directory without .go files is not a plugin (yet, or anymore).
+func (ds *dirSource) notifyDir(action SourceAction, dirName string) {
+
+	sp, hasGoFiles, err := ds.readDirPlugin(dirName)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+		return
+	}
+	if err != nil || !hasGoFiles {
+		if ds.isDirPlugin(dirName) {
+			ds.removeDir(dirName)
+		}
+		return
+	}
+	ds.send(SourceEvent{
+		Action: action,
+		Plugin: sp,
+	})
+}
+
+func (ds *dirSource) isDirPlugin(dirName string) bool {
+
+	ds.dirMutex.Lock()
+	defer ds.dirMutex.Unlock()
+	return ds.dirPlugins[dirName]
+}
+
+func (ds *dirSource) removeDir(dirName string) {
+
+	ds.dirMutex.Lock()
+	delete(ds.dirPlugins, dirName)
+	ds.dirMutex.Unlock()
+	ds.send(SourceEvent{
+		Action: SourceRemove,
+		Plugin: SourcePlugin{Name: dirName},
+	})
+}
+
+func (ds *dirSource) send(event SourceEvent) {
+	select {
+	case ds.changes <- event:
+	case <-ds.closing:
+	}
+}
+
+func (ds *dirSource) readPlugin(fileName string) (SourcePlugin, error) {
+
+	code, err := ioutil.ReadFile(ds.dir + "/" + fileName)
+	if err != nil {
+		return SourcePlugin{}, err
+	}
+	return SourcePlugin{
+		Name:   strings.TrimSuffix(fileName, ".go"),
+		Code:   string(code),
+		Origin: ds.dir + "/" + fileName,
+	}, nil
+}
+
+// readDirPlugin reads all the files of plugin package dirName, and tells whether there are .go files among them
+func (ds *dirSource) readDirPlugin(dirName string) (SourcePlugin, bool, error) {
+
+	root := ds.dir + "/" + dirName
+	files, err := readTree(root)
+	if err != nil {
+		return SourcePlugin{}, false, err
+	}
+	hasGoFiles := false
+	for path := range files {
+		hasGoFiles = hasGoFiles || strings.HasSuffix(path, ".go")
+	}
+	if hasGoFiles {
+		ds.dirMutex.Lock()
+		ds.dirPlugins[dirName] = true
+		ds.dirMutex.Unlock()
+	}
+	return SourcePlugin{
+		Name:   dirName,
+		Files:  files,
+		Origin: root,
+	}, hasGoFiles, nil
+}
+
+// readTree reads the regular files below root, skipping hidden ones, keyed by slash-separated path relative to root
+func readTree(root string) (map[string]string, error) {
+
+	files := make(map[string]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func isCompileUnit(file os.FileInfo) bool {
+	return !file.IsDir() && strings.HasSuffix(file.Name(), ".go")
+}
+
+func isPluginDir(file os.FileInfo) bool {
+	return file.IsDir() && !strings.HasPrefix(file.Name(), ".")
+}
@@ -0,0 +1,129 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CompileError is sent to error subscribers when a plugin does not compile
+type CompileError struct {
+	Plugin string
+	// Origin is the file or consul key the plugin comes from
+	Origin      string
+	Diagnostics []Diagnostic
+	// Output is the compiler output, as is
+	Output string
+}
+
+// Diagnostic is a compiler message about a position in a plugin's source. File is the plugin's origin.
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *CompileError) Error() string {
+
+	if len(e.Diagnostics) == 0 {
+		return "cannot compile " + e.Origin + ": " + strings.TrimSpace(e.Output)
+	}
+	lines := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		lines[i] = d.String()
+	}
+	return "cannot compile " + e.Origin + ":\n" + strings.Join(lines, "\n")
+}
+
+func (d Diagnostic) String() string {
+
+	if d.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+var diagnosticRegexp = regexp.MustCompile(`^(.+\.go):(\d+)(?::(\d+))?: (.*)$`)
+
+/*
+newCompileError parses the output of go build, run in buildDir on srcPath, the file or package directory pluginator
+compiled. Positions in srcPath are reported against the plugin's origin; indented lines continue the previous message.
+*/
+func newCompileError(sp SourcePlugin, buildDir, srcPath, output string) *CompileError {
+
+	ce := &CompileError{
+		Plugin: sp.Name,
+		Origin: sp.Origin,
+		Output: output,
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "\t") && len(ce.Diagnostics) > 0 {
+			last := &ce.Diagnostics[len(ce.Diagnostics)-1]
+			last.Message += "\n" + strings.TrimSpace(line)
+			continue
+		}
+		match := diagnosticRegexp.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		d := Diagnostic{
+			File:    originFile(sp, buildDir, srcPath, match[1]),
+			Message: match[4],
+		}
+		d.Line, _ = strconv.Atoi(match[2])
+		d.Column, _ = strconv.Atoi(match[3])
+		ce.Diagnostics = append(ce.Diagnostics, d)
+	}
+	return ce
+}
+
+// originFile maps a file named in compiler output to the plugin's origin, if it belongs to the plugin
+func originFile(sp SourcePlugin, buildDir, srcPath, file string) string {
+
+	path := file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(buildDir, path)
+	}
+	if sp.Files == nil {
+		if path == srcPath {
+			return sp.Origin
+		}
+		return file
+	}
+	rel, err := filepath.Rel(srcPath, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return file
+	}
+	return sp.Origin + "/" + filepath.ToSlash(rel)
+}
+
+// ModuleMismatchError is sent to error subscribers when a plugin needs versions of modules that the host links too, but
+// at other versions. Such a plugin would not load, so it is not built.
+type ModuleMismatchError struct {
+	Plugin     string
+	Origin     string
+	Mismatches []ModuleMismatch
+}
+
+// ModuleMismatch is a module required by a plugin at a version the host does not have. Versions are in path@version
+// form when the module is replaced.
+type ModuleMismatch struct {
+	Module        string
+	HostVersion   string
+	PluginVersion string
+}
+
+func (e *ModuleMismatchError) Error() string {
+
+	lines := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		lines[i] = e.Origin + ": requires " + m.Module + " " + m.PluginVersion + ", but the host links " + m.HostVersion
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,166 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"sync"
+)
+
+// eventBufferSize is how many events can be waiting to be read on an Events channel before delivery blocks
+const eventBufferSize = 64
+
+// Event is a change in a Pluginator's registry
+type Event struct {
+	Kind EventKind
+	Name string
+	// Old is the version being replaced or removed, or, on errors, the last known good version staying in service
+	Old *PluginContent
+	// New is the version being added, or replacing Old on updates
+	New *PluginContent
+	// Diff is how the exported API changes on updates, nil if it is not known
+	Diff *APIDiff
+	// Err is set on error events. Compilation errors are of type *CompileError, contract violations *ContractError,
+	// updates rejected by the API policy *BreakingChangeError, vetoes of validators *ValidationError, failures of
+	// lifecycle hooks *LifecycleError, processes of plugins that exit *ProcessError, failures to re-execute the host
+	// *ReexecError.
+	Err error
+	// Plugins are the plugins loaded at start time, on scan events
+	Plugins map[string]*PluginContent
+	// Growth is what the plugins loaded into the host take, on restart recommended events; Name is the plugin whose
+	// loading exceeded the growth policy
+	Growth *Growth
+}
+
+// EventKind tells what an Event is about
+type EventKind string
+
+// Event kinds
+const (
+	EventScan   EventKind = "Scan"
+	EventAdd    EventKind = "Add"
+	EventUpdate EventKind = "Update"
+	EventRemove EventKind = "Remove"
+	EventError  EventKind = "Error"
+	// EventRestartRecommended is sent when the plugins loaded into the host exceed the growth policy, after the event of
+	// the plugin version that exceeded it
+	EventRestartRecommended EventKind = "RestartRecommended"
+)
+
+type subscription struct {
+	ctx    context.Context
+	events chan Event
+}
+
+// subscriptions are the open Events channels. Delivery holds the read lock, so that channels are not closed under it.
+type subscriptions struct {
+	mutex sync.RWMutex
+	open  map[*subscription]struct{}
+}
+
+/*
+Events returns a channel on which all events are delivered, in order, until ctx is done or the Pluginator is terminated;
+the channel is then closed.
+Delivery is buffered, but a reader that falls too far behind holds up the other subscribers, so events should be read
+promptly.
+*/
+func (p *Pluginator) Events(ctx context.Context) <-chan Event {
+
+	sub := &subscription{
+		ctx:    ctx,
+		events: make(chan Event, eventBufferSize),
+	}
+	p.subscriptions.mutex.Lock()
+	p.subscriptions.open[sub] = struct{}{}
+	p.subscriptions.mutex.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-p.ctx.Done():
+		}
+		p.subscriptions.mutex.Lock()
+		delete(p.subscriptions.open, sub)
+		close(sub.events)
+		p.subscriptions.mutex.Unlock()
+	}()
+	return sub.events
+}
+
+func (p *Pluginator) emit(event Event) {
+
+	p.subscriptions.mutex.RLock()
+	defer p.subscriptions.mutex.RUnlock()
+	for sub := range p.subscriptions.open {
+		select {
+		case sub.events <- event:
+		case <-sub.ctx.Done():
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+/*
+subscribe calls f, on its own goroutine, for each event of the given kind, until the function it returns is called or the
+Pluginator is terminated. f is not called after the function returned is.
+*/
+func (p *Pluginator) subscribe(kind EventKind, f func(Event)) func() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := p.Events(ctx)
+	go func() {
+		for event := range events {
+			if event.Kind == kind && ctx.Err() == nil {
+				f(event)
+			}
+		}
+	}()
+	return cancel
+}
+
+// SubscribeScan subscribes its argument to scan events (they happen at start time). Like all Subscribe functions, it
+// returns a function that cancels the subscription.
+func (p *Pluginator) SubscribeScan(f func(map[string]*PluginContent)) func() {
+	return p.subscribe(EventScan, func(event Event) {
+		f(event.Plugins)
+	})
+}
+
+// SubscribeUpdate subscribe its argument to update events (changes in plugin code)
+func (p *Pluginator) SubscribeUpdate(f func(string, *PluginContent)) func() {
+	return p.subscribe(EventUpdate, func(event Event) {
+		f(event.Name, event.New)
+	})
+}
+
+// SubscribeReplace subscribes its argument to update events, passing it the version being replaced and the new one
+func (p *Pluginator) SubscribeReplace(f func(string, *PluginContent, *PluginContent)) func() {
+	return p.subscribe(EventUpdate, func(event Event) {
+		f(event.Name, event.Old, event.New)
+	})
+}
+
+// SubscribeRemove subscribes its argument to remove events (plugin removal)
+func (p *Pluginator) SubscribeRemove(f func(string, *PluginContent)) func() {
+	return p.subscribe(EventRemove, func(event Event) {
+		f(event.Name, event.Old)
+	})
+}
+
+// SubscribeAdd subscribes its argument to add events (plugin adds)
+func (p *Pluginator) SubscribeAdd(f func(string, *PluginContent)) func() {
+	return p.subscribe(EventAdd, func(event Event) {
+		f(event.Name, event.New)
+	})
+}
+
+// SubscribeError subscribes its argument to error events (plugins that could not be compiled or loaded). Compilation
+// errors are of type *CompileError. The PluginContent is the last known good version, which stays in service (nil if
+// there is none).
+func (p *Pluginator) SubscribeError(f func(string, *PluginContent, error)) func() {
+	return p.subscribe(EventError, func(event Event) {
+		f(event.Name, event.Old, event.Err)
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+package pluginator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Should be able to receive an event")
+		}
+		return event
+	case <-time.After(30 * time.Second):
+		t.Fatal("Should be able to receive an event in time")
+	}
+	return Event{}
+}
+
+func TestEvents(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = copyTestFile(tempPluginDir+"/plugin1.go", testDataDir+"/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1Code, err := readTestFile(testDataDir + "/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p3Code, err := readTestFile(testDataDir + "/plugin3.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := pluginator.Events(ctx)
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	event := nextEvent(t, events)
+	if event.Kind != EventScan || len(event.Plugins) != 1 || event.Plugins["plugin1"] == nil {
+		t.Fatal("Should be able to receive a scan event")
+	}
+
+	var cancelledCalls int32
+	unsubscribe := pluginator.SubscribeUpdate(func(string, *PluginContent) {
+		atomic.AddInt32(&cancelledCalls, 1)
+	})
+	unsubscribe()
+
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", p3Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || event.Name != "plugin1" {
+		t.Fatal("Should be able to receive an update event")
+	}
+	if event.Old == nil || event.Old.Code != p1Code || event.New == nil || event.New.Code != p3Code {
+		t.Fatal("Should be able to receive the old and new versions on update")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&cancelledCalls) != 0 {
+		t.Fatal("Should be able to cancel a subscription")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("Should be able to unsubscribe")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Should be able to close the events channel on unsubscribe")
+	}
+}
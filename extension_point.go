@@ -0,0 +1,181 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+/*
+ExtensionPoint is a live registry of the values of type T that plugins export under a symbol, keyed by plugin name. It
+follows the adds, updates and removals of its Pluginator until it is closed.
+*/
+type ExtensionPoint[T any] struct {
+	symbol string
+	mutex  sync.RWMutex
+	values map[string]T
+	errs   map[string]error
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// SymbolError tells why a plugin's symbol cannot be bound to an ExtensionPoint
+type SymbolError struct {
+	Plugin string
+	Symbol string
+	// Type is the type of the symbol, nil if the plugin does not export it
+	Type reflect.Type
+	// Want is the type of the ExtensionPoint's values
+	Want reflect.Type
+}
+
+func (e *SymbolError) Error() string {
+
+	if e.Type == nil {
+		return fmt.Sprintf("plugin %s does not export %s", e.Plugin, e.Symbol)
+	}
+	return fmt.Sprintf("plugin %s exports %s as %s, not %s", e.Plugin, e.Symbol, e.Type, e.Want)
+}
+
+/*
+Bind returns an ExtensionPoint holding symbol, as a T, for each plugin of p exporting it. A symbol that is a variable,
+which plugins return as a pointer, is dereferenced unless T is the pointer type. Plugins whose symbol is missing or of
+another type are left out, and their error is available from Err.
+*/
+func Bind[T any](p *Pluginator, symbol string) *ExtensionPoint[T] {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ep := &ExtensionPoint[T]{
+		symbol: symbol,
+		values: make(map[string]T),
+		errs:   make(map[string]error),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	// subscribe before reading the registry, so that no change is missed: changes already in the snapshot are applied
+	// twice, to the same result
+	events := p.Events(ctx)
+	for name, pc := range p.Snapshot() {
+		ep.bind(name, pc)
+	}
+	go func() {
+		defer close(ep.done)
+		for event := range events {
+			switch event.Kind {
+			case EventScan:
+				for name, pc := range event.Plugins {
+					ep.bind(name, pc)
+				}
+			case EventAdd, EventUpdate:
+				ep.bind(event.Name, event.New)
+			case EventRemove:
+				ep.unbind(event.Name)
+			}
+		}
+	}()
+	return ep
+}
+
+// Get returns the value plugin name exports, if it is loaded and exports it with the right type
+func (ep *ExtensionPoint[T]) Get(name string) (T, bool) {
+
+	ep.mutex.RLock()
+	defer ep.mutex.RUnlock()
+	value, exists := ep.values[name]
+	return value, exists
+}
+
+// All returns a copy of the values, keyed by plugin name
+func (ep *ExtensionPoint[T]) All() map[string]T {
+
+	ep.mutex.RLock()
+	defer ep.mutex.RUnlock()
+	values := make(map[string]T, len(ep.values))
+	for name, value := range ep.values {
+		values[name] = value
+	}
+	return values
+}
+
+// Names returns the sorted names of the plugins bound to the ExtensionPoint
+func (ep *ExtensionPoint[T]) Names() []string {
+
+	ep.mutex.RLock()
+	defer ep.mutex.RUnlock()
+	names := make([]string, 0, len(ep.values))
+	for name := range ep.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Err returns why the active version of plugin name could not be bound, as a *SymbolError, or nil
+func (ep *ExtensionPoint[T]) Err(name string) error {
+
+	ep.mutex.RLock()
+	defer ep.mutex.RUnlock()
+	return ep.errs[name]
+}
+
+// Close stops following the Pluginator. The values bound so far stay available.
+func (ep *ExtensionPoint[T]) Close() {
+
+	ep.cancel()
+	<-ep.done
+}
+
+func (ep *ExtensionPoint[T]) bind(name string, pc *PluginContent) {
+
+	value, err := lookup[T](name, pc, ep.symbol)
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	if err != nil {
+		log.Println(err)
+		delete(ep.values, name)
+		ep.errs[name] = err
+		return
+	}
+	ep.values[name] = value
+	delete(ep.errs, name)
+}
+
+func (ep *ExtensionPoint[T]) unbind(name string) {
+
+	ep.mutex.Lock()
+	defer ep.mutex.Unlock()
+	delete(ep.values, name)
+	delete(ep.errs, name)
+}
+
+// lookup returns symbol from a plugin as a T, dereferencing it if it is a pointer to a T
+func lookup[T any](name string, pc *PluginContent, symbol string) (T, error) {
+
+	var zero T
+	symbolErr := &SymbolError{
+		Plugin: name,
+		Symbol: symbol,
+		Want:   reflect.TypeOf(&zero).Elem(),
+	}
+	sym, err := pc.Lib.Lookup(symbol)
+	if err != nil {
+		return zero, symbolErr
+	}
+	if value, ok := sym.(T); ok {
+		return value, nil
+	}
+	symValue := reflect.ValueOf(sym)
+	if symValue.Kind() == reflect.Ptr && !symValue.IsNil() {
+		if value, ok := symValue.Elem().Interface().(T); ok {
+			return value, nil
+		}
+	}
+	symbolErr.Type = symValue.Type()
+	return zero, symbolErr
+}
@@ -0,0 +1,105 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// waitFor waits for condition to become true
+func waitFor(condition func() bool) bool {
+
+	deadline := time.Now().Add(30 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+func TestExtensionPoint(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = copyTestFile(tempPluginDir+"/plugin1.go", testDataDir+"/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = copyTestFile(tempPluginDir+"/plugin3.go", testDataDir+"/plugin3.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1Code, err := readTestFile(testDataDir + "/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	adders := Bind[func(int, int) int](pluginator, "Add")
+	defer adders.Close()
+
+	add, exists := adders.Get("plugin1")
+	if !exists || add(1, 2) != 3 {
+		t.Fatal("Should be able to bind a symbol of the plugins loaded before binding")
+	}
+	if len(adders.All()) != 1 || len(adders.Names()) != 1 {
+		t.Fatal("Should be able to leave out plugins not exporting a symbol")
+	}
+	se, ok := adders.Err("plugin3").(*SymbolError)
+	if !ok || se.Type != nil {
+		t.Fatal("Should be able to report a missing symbol")
+	}
+
+	ints := Bind[int](pluginator, "Add")
+	defer ints.Close()
+	se, ok = ints.Err("plugin1").(*SymbolError)
+	if !ok || se.Type == nil || se.Type.String() != "func(int, int) int" {
+		t.Fatal("Should be able to report a symbol of the wrong type")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/plugin3.go", p1Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { _, exists := adders.Get("plugin3"); return exists }) || adders.Err("plugin3") != nil {
+		t.Fatal("Should be able to bind the symbol of an updated plugin")
+	}
+
+	factors := Bind[int](pluginator, "Factor")
+	defer factors.Close()
+	err = createTestFile(tempPluginDir+"/factor.go", "package main\n\nvar Factor = 3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { factor, _ := factors.Get("factor"); return factor == 3 }) {
+		t.Fatal("Should be able to bind a variable of an added plugin")
+	}
+
+	err = deleteTestFile(tempPluginDir + "/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { _, exists := adders.Get("plugin1"); return !exists }) {
+		t.Fatal("Should be able to unbind a removed plugin")
+	}
+}
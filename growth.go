@@ -0,0 +1,129 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"os"
+	"sync"
+)
+
+/*
+Growth is what the versions of plugins loaded into the host take. Libraries opened with plugin.Open can never be
+unloaded, so the memory and mapped libraries of the process only grow with updates, until it is restarted.
+*/
+type Growth struct {
+	// Plugins is the growth of each plugin
+	Plugins map[string]PluginGrowth
+	// Versions are the distinct libraries loaded, Bytes their size on disk, Loads the times libraries were opened
+	Versions int
+	Bytes    int64
+	Loads    int
+}
+
+// PluginGrowth is what the versions of a plugin loaded into the host take
+type PluginGrowth struct {
+	Versions int
+	Bytes    int64
+	Loads    int
+}
+
+/*
+GrowthPolicy sets limits to the growth of the host. When one is exceeded for the first time, a restart is recommended: an
+EventRestartRecommended is sent, and OnRestartRecommended, if not nil, called on its own goroutine. Zero means no limit.
+*/
+type GrowthPolicy struct {
+	MaxVersionsPerPlugin int
+	MaxVersions          int
+	MaxBytes             int64
+	OnRestartRecommended func(Growth)
+}
+
+// growth tracks the libraries loaded into the host
+type growth struct {
+	mutex       sync.Mutex
+	loaded      map[string]bool
+	plugins     map[string]PluginGrowth
+	total       PluginGrowth
+	recommended bool
+}
+
+// Growth returns what the versions of plugins loaded into the host take
+func (p *Pluginator) Growth() Growth {
+
+	p.growth.mutex.Lock()
+	defer p.growth.mutex.Unlock()
+	g := Growth{
+		Plugins:  make(map[string]PluginGrowth, len(p.growth.plugins)),
+		Versions: p.growth.total.Versions,
+		Bytes:    p.growth.total.Bytes,
+		Loads:    p.growth.total.Loads,
+	}
+	for name, pg := range p.growth.plugins {
+		g.Plugins[name] = pg
+	}
+	return g
+}
+
+/*
+recordLoad records that lib, a version of plugin name, was loaded from artifact, if it runs in the host: even if it does
+not become active, it cannot be unloaded. Opening a library again does not load it again, so it only counts as a load. It
+tells whether the growth policy is exceeded for the first time.
+*/
+func (p *Pluginator) recordLoad(name, artifact string, lib Symbols) bool {
+
+	if _, remote := outOfProcess(lib); remote {
+		return false
+	}
+	p.growth.mutex.Lock()
+	defer p.growth.mutex.Unlock()
+	if p.growth.loaded == nil {
+		p.growth.loaded = make(map[string]bool)
+		p.growth.plugins = make(map[string]PluginGrowth)
+	}
+	pg := p.growth.plugins[name]
+	pg.Loads++
+	p.growth.total.Loads++
+	if !p.growth.loaded[artifact] {
+		p.growth.loaded[artifact] = true
+		var size int64
+		if info, err := os.Stat(artifact); err == nil {
+			size = info.Size()
+		}
+		pg.Versions++
+		pg.Bytes += size
+		p.growth.total.Versions++
+		p.growth.total.Bytes += size
+	}
+	p.growth.plugins[name] = pg
+	exceeded := !p.growth.recommended && p.growthPolicy != nil && p.growthPolicy.exceeded(pg, p.growth.total)
+	if exceeded {
+		p.growth.recommended = true
+	}
+	return exceeded
+}
+
+// recommendRestart recommends a restart, as loading plugin name exceeded the growth policy
+func (p *Pluginator) recommendRestart(name string) {
+
+	g := p.Growth()
+	p.emit(Event{
+		Kind:   EventRestartRecommended,
+		Name:   name,
+		Growth: &g,
+	})
+	if p.growthPolicy.OnRestartRecommended != nil {
+		go p.growthPolicy.OnRestartRecommended(g)
+	}
+	if p.drain != nil {
+		go p.reexec(name)
+	}
+}
+
+// exceeded tells whether a plugin growing to pg, or the host to total, exceeds the policy
+func (gp *GrowthPolicy) exceeded(pg, total PluginGrowth) bool {
+
+	return gp.MaxVersionsPerPlugin > 0 && pg.Versions > gp.MaxVersionsPerPlugin ||
+		gp.MaxVersions > 0 && total.Versions > gp.MaxVersions ||
+		gp.MaxBytes > 0 && total.Bytes > gp.MaxBytes
+}
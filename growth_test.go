@@ -0,0 +1,125 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestGrowthPolicy(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = createTestFile(tempPluginDir+"/grower.go", "package main\n\nvar Version = 1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recommended := make(chan Growth, 1)
+	pluginator, err := NewPluginatorF(tempPluginDir, WithGrowthPolicy(GrowthPolicy{
+		MaxVersionsPerPlugin: 2,
+		OnRestartRecommended: func(growth Growth) {
+			recommended <- growth
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	if event.Kind != EventScan {
+		t.Fatal("Should be able to receive a scan event")
+	}
+	growth := pluginator.Growth()
+	if growth.Versions != 1 || growth.Loads != 1 || growth.Bytes == 0 || growth.Plugins["grower"] != (PluginGrowth{Versions: 1, Bytes: growth.Bytes, Loads: 1}) {
+		t.Fatal("Should be able to track the versions loaded, got ", growth)
+	}
+
+	err = replaceTestFile(tempPluginDir+"/grower.go", "package main\n\nvar Version = 2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || pluginator.Growth().Plugins["grower"].Versions != 2 {
+		t.Fatal("Should be able to stay within the growth policy")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/grower.go", "package main\n\nvar Version = 3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate {
+		t.Fatal("Should be able to load plugins beyond the growth policy")
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventRestartRecommended || event.Name != "grower" || event.Growth.Versions != 3 || event.Growth.Loads != 3 {
+		t.Fatal("Should be able to recommend a restart after the update that exceeds the growth policy")
+	}
+	growth = <-recommended
+	if growth.Plugins["grower"].Versions != 3 {
+		t.Fatal("Should be able to call the handler when the growth policy is exceeded")
+	}
+
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoaderGrowth(t *testing.T) {
+
+	source := newFakeSource(SourcePlugin{Name: "greeter", Code: "Greeting=hello\n"})
+	pluginator, err := NewPluginator(source, WithBuilder(fakeBuilder{}), WithLoader(fakeLoader{}),
+		WithGrowthPolicy(GrowthPolicy{MaxVersions: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pluginator.AddValidator("veto", func(name string, candidate, active *PluginContent) error {
+		if name == "vetoed" {
+			return errors.New("vetoed")
+		}
+		return nil
+	})
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	if event.Kind != EventScan || pluginator.Growth().Plugins["greeter"].Versions != 1 {
+		t.Fatal("Should be able to track the versions loaded by a custom loader")
+	}
+
+	source.changes <- SourceEvent{Action: SourceAdd, Plugin: SourcePlugin{Name: "namer", Code: "Name=pluginator\n"}}
+	event = nextEvent(t, events)
+	if event.Kind != EventAdd || event.Name != "namer" {
+		t.Fatal("Should be able to stay within the growth policy")
+	}
+	source.changes <- SourceEvent{Action: SourceAdd, Plugin: SourcePlugin{Name: "vetoed", Code: "Name=vetoed\n"}}
+	event = nextEvent(t, events)
+	if event.Kind != EventError || event.Name != "vetoed" {
+		t.Fatal("Should be able to receive an error event before the restart recommendation")
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventRestartRecommended || event.Name != "vetoed" || event.Growth.Versions != 3 {
+		t.Fatal("Should be able to count the versions loaded but rejected, which cannot be unloaded")
+	}
+
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
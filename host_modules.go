@@ -0,0 +1,223 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+/*
+hostModules are the modules linked into the host binary, keyed by path. A package shared by the host and a plugin must
+be compiled from the very same source, so the modules of plugin packages that have their own go.mod are pinned to them.
+*/
+type hostModules map[string]*debug.Module
+
+// readHostModules returns the modules of the host binary, or nil if it was built without module support
+func readHostModules() hostModules {
+
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	modules := make(hostModules, len(buildInfo.Deps))
+	for _, dep := range buildInfo.Deps {
+		modules[dep.Path] = dep
+	}
+	return modules
+}
+
+// key identifies the host modules in cache keys
+func (hm hostModules) key() string {
+
+	paths := make([]string, 0, len(hm))
+	for path := range hm {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	h := sha256.New()
+	for _, path := range paths {
+		h.Write([]byte(moduleVersion(hm[path])))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// goModJSON is the part of the output of go mod edit -json that pinning needs
+type goModJSON struct {
+	Require []struct {
+		Path    string
+		Version string
+	}
+	Replace []struct {
+		Old struct {
+			Path    string
+			Version string
+		}
+		New struct {
+			Path    string
+			Version string
+		}
+	}
+}
+
+/*
+pin returns the go mod edit arguments replacing the modules the plugin module in dir shares with the host with the
+host's versions, and the go.sum lines of the versions. A plugin requiring a newer version of a module than the host's, or
+replacing it with something else, cannot be pinned: that is reported as a *ModuleMismatchError.
+*/
+func (p *Pluginator) pin(ctx context.Context, dir string, sp SourcePlugin) ([]string, []string, error) {
+
+	if len(p.hostModules) == 0 {
+		return nil, nil, nil
+	}
+	command := p.goCommand(ctx, "mod", "edit", "-json")
+	command.Dir = dir
+	var stdErr bytes.Buffer
+	command.Stderr = &stdErr
+	output, err := command.Output()
+	if err != nil {
+		return nil, nil, errors.New("cannot read go.mod: " + strings.TrimSpace(stdErr.String()))
+	}
+	var goMod goModJSON
+	if err = json.Unmarshal(output, &goMod); err != nil {
+		return nil, nil, err
+	}
+	replaced := make(map[string]string)
+	for _, replace := range goMod.Replace {
+		replaced[replace.Old.Path] = strings.TrimSuffix(replace.New.Path+"@"+replace.New.Version, "@")
+	}
+
+	var args, sums []string
+	var mismatches []ModuleMismatch
+	for _, require := range goMod.Require {
+		hostModule, shared := p.hostModules[require.Path]
+		if !shared {
+			continue
+		}
+		hostVersion := moduleVersion(hostModule)
+		mismatch := ModuleMismatch{
+			Module:        require.Path,
+			HostVersion:   hostModule.Version,
+			PluginVersion: require.Version,
+		}
+		if hostModule.Replace != nil {
+			mismatch.HostVersion = hostVersion
+		}
+		replacement, isReplaced := replaced[require.Path]
+		switch {
+		case isReplaced && replacement != hostVersion:
+			mismatch.PluginVersion = replacement
+			mismatches = append(mismatches, mismatch)
+		case hostModule.Replace != nil && hostModule.Replace.Version == "" && !filepath.IsAbs(hostModule.Replace.Path):
+			// the host replaces the module with a directory relative to its own module, which is not known
+			mismatches = append(mismatches, mismatch)
+		case hostModule.Replace == nil && semver.Compare(require.Version, hostModule.Version) > 0:
+			mismatches = append(mismatches, mismatch)
+		default:
+			args = append(args, "-replace="+require.Path+"="+hostVersion)
+			moduleSums, err := p.moduleSums(ctx, dir, hostModule)
+			if err != nil {
+				return nil, nil, err
+			}
+			sums = append(sums, moduleSums...)
+		}
+	}
+	if len(mismatches) > 0 {
+		return nil, nil, &ModuleMismatchError{
+			Plugin:     sp.Name,
+			Origin:     sp.Origin,
+			Mismatches: mismatches,
+		}
+	}
+	return args, sums, nil
+}
+
+/*
+moduleSums returns the go.sum lines of the version of module the host links, downloading it if it is not in the module
+cache. The sum of the download must be the one the host binary records. Modules replaced with directories have none.
+*/
+func (p *Pluginator) moduleSums(ctx context.Context, dir string, module *debug.Module) ([]string, error) {
+
+	if module.Replace != nil {
+		module = module.Replace
+	}
+	if module.Version == "" {
+		return nil, nil
+	}
+	command := p.goCommand(ctx, "mod", "download", "-json", module.Path+"@"+module.Version)
+	command.Dir = dir
+	output, err := command.Output()
+	var download struct {
+		Sum      string
+		GoModSum string
+		Error    string
+	}
+	if jsonErr := json.Unmarshal(output, &download); jsonErr != nil {
+		if err == nil {
+			err = jsonErr
+		}
+		return nil, errors.New("cannot download " + module.Path + "@" + module.Version + ": " + err.Error())
+	}
+	if download.Error != "" {
+		return nil, errors.New("cannot download " + module.Path + "@" + module.Version + ": " + download.Error)
+	}
+	if module.Sum != "" && download.Sum != module.Sum {
+		return nil, errors.New("the sum of " + module.Path + "@" + module.Version + " is " + download.Sum + ", but the host was built with " + module.Sum)
+	}
+	return []string{
+		module.Path + " " + module.Version + " " + download.Sum,
+		module.Path + " " + module.Version + "/go.mod " + download.GoModSum,
+	}, nil
+}
+
+// addSums adds the go.sum lines sums, that it does not have yet, to the go.sum of the module in dir
+func addSums(dir string, sums []string) error {
+
+	if len(sums) == 0 {
+		return nil
+	}
+	content, err := ioutil.ReadFile(dir + "/go.sum")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		known[line] = true
+	}
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	for _, sum := range sums {
+		if !known[sum] {
+			known[sum] = true
+			content = append(content, sum+"\n"...)
+		}
+	}
+	return ioutil.WriteFile(dir+"/go.sum", content, 0600)
+}
+
+// moduleVersion returns where the host took module from, as a replacement: path@version, or a directory
+func moduleVersion(module *debug.Module) string {
+
+	if module.Replace != nil {
+		module = module.Replace
+	}
+	if module.Version == "" {
+		return module.Path
+	}
+	return module.Path + "@" + module.Version
+}
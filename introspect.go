@@ -0,0 +1,399 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// API is what a plugin exports, as read from its source. Constants are left out, as plugins cannot look them up.
+type API struct {
+	Funcs []Export
+	Vars  []Export
+	Types []Export
+}
+
+// Export is an exported function, variable or type of a plugin, or a method of an exported type
+type Export struct {
+	Name string
+	// Type is the signature of a function or method, e.g. func(x int, y int) int, the type of a variable, or the
+	// definition of a type. Types of other packages are qualified by their import path. Unexported fields and
+	// interface methods are left out.
+	Type string
+	// Signature is Type without the names of parameters and results, which do not matter to clients, so that renaming
+	// them does not change the API
+	Signature string `json:",omitempty"`
+	Doc       string
+	// Methods are the exported methods of a type, including those of its pointer
+	Methods []Export `json:",omitempty"`
+}
+
+// clone returns a deep copy of api, nil if api is nil
+func (api *API) clone() *API {
+
+	if api == nil {
+		return nil
+	}
+	return &API{
+		Funcs: cloneExports(api.Funcs),
+		Vars:  cloneExports(api.Vars),
+		Types: cloneExports(api.Types),
+	}
+}
+
+func cloneExports(exports []Export) []Export {
+
+	if exports == nil {
+		return nil
+	}
+	clones := make([]Export, len(exports))
+	for i, export := range exports {
+		clones[i] = export
+		clones[i].Methods = cloneExports(export.Methods)
+	}
+	return clones
+}
+
+// listedPackage is the part of the output of go list -json that introspection needs
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Export     string
+	GoFiles    []string
+	CgoFiles   []string
+	DepOnly    bool
+}
+
+/*
+api returns the API of a plugin that was built, from the cache if it was extracted before. A plugin whose API cannot be
+extracted still works: the error is logged, and nil returned.
+*/
+func (p *Pluginator) api(ctx context.Context, sp SourcePlugin, versionedName string) *API {
+
+	apiFile := p.cacheDir + "/" + versionedName + ".api.json"
+	api := &API{}
+	content, err := ioutil.ReadFile(apiFile)
+	if err == nil {
+		if err = json.Unmarshal(content, api); err == nil {
+			return api
+		}
+	}
+	api, err = p.introspect(ctx, sp, versionedName)
+	if err != nil {
+		log.Println("Cannot read the API of", sp.Name+":", err)
+		return nil
+	}
+	content, err = json.Marshal(api)
+	if err == nil {
+		err = writeFileAtomic(apiFile, content)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	return api
+}
+
+/*
+introspect type-checks the source of a plugin that was built. The go tool tells which files are compiled, and where the
+export data of the packages they import is.
+*/
+func (p *Pluginator) introspect(ctx context.Context, sp SourcePlugin, versionedName string) (*API, error) {
+
+	buildDir, target := p.buildTarget(sp, versionedName)
+	args := append([]string{"list"}, p.buildArgs(sp)...)
+	args = append(args, "-export", "-deps", "-json", target)
+	command := p.goCommand(ctx, args...)
+	command.Dir = buildDir
+	var stdErr bytes.Buffer
+	command.Stderr = &stdErr
+	out, err := command.Output()
+	if err != nil {
+		return nil, errors.New("cannot list packages: " + strings.TrimSpace(stdErr.String()))
+	}
+
+	exports := make(map[string]string)
+	var mainPkg listedPackage
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var pkg listedPackage
+		err = decoder.Decode(&pkg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pkg.DepOnly {
+			exports[pkg.ImportPath] = pkg.Export
+		} else {
+			mainPkg = pkg
+		}
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, fileName := range append(mainPkg.GoFiles, mainPkg.CgoFiles...) {
+		file, err := parser.ParseFile(fset, filepath.Join(mainPkg.Dir, fileName), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	config := types.Config{
+		Importer: importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+			exportFile, exists := exports[path]
+			if !exists || exportFile == "" {
+				return nil, errors.New("no export data for " + path)
+			}
+			return os.Open(exportFile)
+		}),
+		FakeImportC: true,
+	}
+	pkg, err := config.Check(mainPkg.ImportPath, fset, files, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the packages of the plugin are built under a module path unique to the version, and qualified by their own
+	ownPath := "."
+	if goMod, hasGoMod := sp.Files["go.mod"]; hasGoMod && modfile.ModulePath([]byte(goMod)) != "" {
+		ownPath = modfile.ModulePath([]byte(goMod))
+	}
+	return newAPI(pkg, ownPath, docs(files)), nil
+}
+
+/*
+newAPI returns the exports of pkg, in the order of their names. Types of the packages below pkg are qualified by their
+path below ownPath.
+*/
+func newAPI(pkg *types.Package, ownPath string, docs map[string]string) *API {
+
+	qualifier := func(other *types.Package) string {
+		switch {
+		case other == pkg:
+			return ""
+		case strings.HasPrefix(other.Path(), pkg.Path()+"/"):
+			return ownPath + strings.TrimPrefix(other.Path(), pkg.Path())
+		}
+		return other.Path()
+	}
+	api := &API{}
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+		export := Export{
+			Name:      name,
+			Type:      types.TypeString(obj.Type(), qualifier),
+			Signature: signature(obj.Type(), qualifier),
+			Doc:       docs[name],
+		}
+		switch obj := obj.(type) {
+		case *types.Func:
+			api.Funcs = append(api.Funcs, export)
+		case *types.Var:
+			export.Type = types.TypeString(exportedType(obj.Type()), qualifier)
+			export.Signature = signature(exportedType(obj.Type()), qualifier)
+			api.Vars = append(api.Vars, export)
+		case *types.TypeName:
+			export.Type = types.TypeString(exportedType(obj.Type().Underlying()), qualifier)
+			export.Signature = signature(exportedType(obj.Type().Underlying()), qualifier)
+			methods := types.NewMethodSet(types.NewPointer(obj.Type()))
+			for i := 0; i < methods.Len(); i++ {
+				method := methods.At(i).Obj()
+				if method.Exported() {
+					export.Methods = append(export.Methods, Export{
+						Name:      method.Name(),
+						Type:      types.TypeString(method.Type(), qualifier),
+						Signature: signature(method.Type(), qualifier),
+						Doc:       docs[name+"."+method.Name()],
+					})
+				}
+			}
+			api.Types = append(api.Types, export)
+		}
+	}
+	return api
+}
+
+/*
+exportedType returns t without the unexported fields of its structs and the unexported methods of its interfaces, which
+clients of the plugin cannot use, so that changing them does not change the API.
+*/
+func exportedType(t types.Type) types.Type {
+
+	switch t := t.(type) {
+	case *types.Struct:
+		var fields []*types.Var
+		var tags []string
+		for i := 0; i < t.NumFields(); i++ {
+			field := t.Field(i)
+			if field.Exported() {
+				fields = append(fields, types.NewField(field.Pos(), field.Pkg(), field.Name(), exportedType(field.Type()), field.Embedded()))
+				tags = append(tags, t.Tag(i))
+			}
+		}
+		return types.NewStruct(fields, tags)
+	case *types.Interface:
+		if !t.IsMethodSet() {
+			// a constraint, whose type terms must be kept
+			return t
+		}
+		var methods []*types.Func
+		for i := 0; i < t.NumMethods(); i++ {
+			if method := t.Method(i); method.Exported() {
+				methods = append(methods, method)
+			}
+		}
+		return types.NewInterfaceType(methods, nil).Complete()
+	case *types.Pointer:
+		return types.NewPointer(exportedType(t.Elem()))
+	case *types.Slice:
+		return types.NewSlice(exportedType(t.Elem()))
+	case *types.Array:
+		return types.NewArray(exportedType(t.Elem()), t.Len())
+	case *types.Map:
+		return types.NewMap(exportedType(t.Key()), exportedType(t.Elem()))
+	}
+	return t
+}
+
+// signature returns the type string of t without the names of parameters and results, keeping the type parameters of t
+func signature(t types.Type, qualifier types.Qualifier) string {
+
+	sig, isSignature := t.(*types.Signature)
+	if !isSignature || sig.TypeParams().Len() == 0 {
+		return types.TypeString(unnamed(t), qualifier)
+	}
+	var typeParams []string
+	for i := 0; i < sig.TypeParams().Len(); i++ {
+		typeParam := sig.TypeParams().At(i)
+		typeParams = append(typeParams, typeParam.Obj().Name()+" "+types.TypeString(typeParam.Constraint(), qualifier))
+	}
+	// type parameters cannot be bound to a new signature, so they are written apart
+	return "func[" + strings.Join(typeParams, ", ") + "]" + strings.TrimPrefix(types.TypeString(unnamed(sig), qualifier), "func")
+}
+
+// unnamed returns t without the names of the parameters and results of its signatures, or their type parameters
+func unnamed(t types.Type) types.Type {
+
+	switch t := t.(type) {
+	case *types.Signature:
+		return types.NewSignatureType(nil, nil, nil, unnamedTuple(t.Params()), unnamedTuple(t.Results()), t.Variadic())
+	case *types.Struct:
+		fields := make([]*types.Var, t.NumFields())
+		tags := make([]string, t.NumFields())
+		for i := range fields {
+			field := t.Field(i)
+			fields[i] = types.NewField(field.Pos(), field.Pkg(), field.Name(), unnamed(field.Type()), field.Embedded())
+			tags[i] = t.Tag(i)
+		}
+		return types.NewStruct(fields, tags)
+	case *types.Interface:
+		if !t.IsMethodSet() {
+			return t
+		}
+		methods := make([]*types.Func, t.NumMethods())
+		for i := range methods {
+			method := t.Method(i)
+			methods[i] = types.NewFunc(method.Pos(), method.Pkg(), method.Name(), unnamed(method.Type()).(*types.Signature))
+		}
+		return types.NewInterfaceType(methods, nil).Complete()
+	case *types.Pointer:
+		return types.NewPointer(unnamed(t.Elem()))
+	case *types.Slice:
+		return types.NewSlice(unnamed(t.Elem()))
+	case *types.Array:
+		return types.NewArray(unnamed(t.Elem()), t.Len())
+	case *types.Map:
+		return types.NewMap(unnamed(t.Key()), unnamed(t.Elem()))
+	case *types.Chan:
+		return types.NewChan(t.Dir(), unnamed(t.Elem()))
+	}
+	return t
+}
+
+func unnamedTuple(tuple *types.Tuple) *types.Tuple {
+
+	vars := make([]*types.Var, tuple.Len())
+	for i := range vars {
+		v := tuple.At(i)
+		vars[i] = types.NewParam(v.Pos(), v.Pkg(), "", unnamed(v.Type()))
+	}
+	return types.NewTuple(vars...)
+}
+
+// docs returns the doc comments of the top-level declarations in files, keyed by name, or type.method for methods
+func docs(files []*ast.File) map[string]string {
+
+	docs := make(map[string]string)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				name := decl.Name.Name
+				if decl.Recv != nil && len(decl.Recv.List) == 1 {
+					name = receiverName(decl.Recv.List[0].Type) + "." + name
+				}
+				docs[name] = strings.TrimSpace(decl.Doc.Text())
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					// the doc of a group of declarations only documents a lone declaration
+					var doc *ast.CommentGroup
+					if len(decl.Specs) == 1 {
+						doc = decl.Doc
+					}
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						if spec.Doc != nil {
+							doc = spec.Doc
+						}
+						docs[spec.Name.Name] = strings.TrimSpace(doc.Text())
+					case *ast.ValueSpec:
+						if spec.Doc != nil {
+							doc = spec.Doc
+						}
+						for _, name := range spec.Names {
+							docs[name.Name] = strings.TrimSpace(doc.Text())
+						}
+					}
+				}
+			}
+		}
+	}
+	return docs
+}
+
+// receiverName returns the name of the type of a method receiver, e.g. T for *T or T[K]
+func receiverName(expr ast.Expr) string {
+
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(expr.X)
+	case *ast.IndexExpr:
+		return receiverName(expr.X)
+	case *ast.IndexListExpr:
+		return receiverName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIntrospect(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+	tempCacheDir, err := ioutil.TempDir("", "testcachedir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempCacheDir)
+
+	code := `package main
+
+import "time"
+
+// Add adds x and y
+func Add(x, y int) int {
+	return x + y
+}
+
+// Apply applies f to each of xs
+func Apply[T any](xs []T, f func(x T) T) []T {
+	for i := range xs {
+		xs[i] = f(xs[i])
+	}
+	return xs
+}
+
+// Timeout is how long to wait
+var Timeout = time.Second
+
+// Counter counts
+type Counter struct {
+	n int
+}
+
+// Inc increments the counter
+func (c *Counter) Inc() {
+	c.n++
+}
+
+func (c Counter) Value() int {
+	return c.n
+}
+
+const Max = 10
+
+func helper() {
+}
+`
+	err = ioutil.WriteFile(tempPluginDir+"/counter.go", []byte(code), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir, WithCacheDir(tempCacheDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	event := nextEvent(t, events)
+	counter, exists := event.Plugins["counter"]
+	if event.Kind != EventScan || !exists || counter.API == nil {
+		t.Fatal("Should be able to read the API of a plugin")
+	}
+	api := counter.API
+	if len(api.Funcs) != 2 || api.Funcs[0].Name != "Add" || api.Funcs[0].Type != "func(x int, y int) int" || api.Funcs[0].Doc != "Add adds x and y" {
+		t.Fatal("Should be able to read the exported functions of a plugin, got ", api.Funcs)
+	}
+	if api.Funcs[0].Signature != "func(int, int) int" || api.Funcs[1].Signature != "func[T any]([]T, func(T) T) []T" {
+		t.Fatal("Should be able to read the signatures of functions without the names of parameters, got ", api.Funcs)
+	}
+	if len(api.Vars) != 1 || api.Vars[0].Name != "Timeout" || api.Vars[0].Type != "time.Duration" || api.Vars[0].Doc != "Timeout is how long to wait" {
+		t.Fatal("Should be able to read the exported variables of a plugin, got ", api.Vars)
+	}
+	if len(api.Types) != 1 || api.Types[0].Name != "Counter" || api.Types[0].Type != "struct{}" {
+		t.Fatal("Should be able to read the exported types of a plugin, got ", api.Types)
+	}
+	methods := api.Types[0].Methods
+	if len(methods) != 2 || methods[0].Name != "Inc" || methods[0].Type != "func()" || methods[0].Doc != "Inc increments the counter" || methods[1].Name != "Value" {
+		t.Fatal("Should be able to read the methods of the exported types of a plugin, got ", methods)
+	}
+
+	apiFiles, err := filepath.Glob(tempCacheDir + "/counter-*.api.json")
+	if err != nil || len(apiFiles) != 1 {
+		t.Fatal("Should be able to cache the API of a plugin")
+	}
+}
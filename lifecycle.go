@@ -0,0 +1,190 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// DefaultHookTimeout is how long lifecycle hooks and validators can run, unless WithHookTimeout says otherwise
+const DefaultHookTimeout = 10 * time.Second
+
+// Lifecycle hooks, optionally exported by plugins
+const (
+	// InitHook is func Init(host interface{}) error, called before a plugin goes live, with the value given by WithHost.
+	// With ProcessRuntime, the process of the plugin calls it with nil whenever it starts.
+	InitHook = "Init"
+	// ShutdownHook is func Shutdown() error, called when a plugin is replaced, removed or terminated
+	ShutdownHook = "Shutdown"
+	// ExportStateHook is func ExportState() (interface{}, error), called on the version being replaced
+	ExportStateHook = "ExportState"
+	// ImportStateHook is func ImportState(state interface{}) error, called on the replacing version, with the state
+	// exported by the version it replaces
+	ImportStateHook = "ImportState"
+)
+
+// LifecycleError is sent to error subscribers when a lifecycle hook of a plugin fails, times out or panics
+type LifecycleError struct {
+	Plugin string
+	Hook   string
+	Err    error
+}
+
+func (e *LifecycleError) Error() string {
+	return e.Hook + " of " + e.Plugin + " failed: " + e.Err.Error()
+}
+
+func (e *LifecycleError) Unwrap() error {
+	return e.Err
+}
+
+/*
+initialize calls Init on candidate, then hands the state of active, if any, over to it. If the handoff fails, candidate
+is shut down.
+*/
+func (p *Pluginator) initialize(name string, candidate, active *PluginContent) error {
+
+	err := p.callHook(name, candidate, InitHook, func(hook interface{}) error {
+		return hook.(func(interface{}) error)(p.host)
+	})
+	if err != nil || active == nil {
+		return err
+	}
+	err = p.handOff(name, active, candidate)
+	if err != nil {
+		p.discard(name, candidate)
+	}
+	return err
+}
+
+// handOff moves the state of plugin name from one version to the next, if both have the hooks for it
+func (p *Pluginator) handOff(name string, from, to *PluginContent) error {
+
+	if !hasHook(from, ExportStateHook) || !hasHook(to, ImportStateHook) {
+		return nil
+	}
+	var state interface{}
+	err := p.callHook(name, from, ExportStateHook, func(hook interface{}) (err error) {
+		state, err = hook.(func() (interface{}, error))()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return p.callHook(name, to, ImportStateHook, func(hook interface{}) error {
+		return hook.(func(interface{}) error)(state)
+	})
+}
+
+// shutdown calls Shutdown on a version of plugin name, or closes it if it runs outside the host
+func (p *Pluginator) shutdown(name string, pc *PluginContent) error {
+
+	if remote, isRemote := outOfProcess(pc.Lib); isRemote {
+		return remote.Close()
+	}
+	return p.callHook(name, pc, ShutdownHook, func(hook interface{}) error {
+		return hook.(func() error)()
+	})
+}
+
+// discard shuts down a version of plugin name that did not go live, logging errors
+func (p *Pluginator) discard(name string, candidate *PluginContent) {
+
+	if err := p.shutdown(name, candidate); err != nil {
+		log.Println(err)
+	}
+}
+
+// retire shuts down a version of plugin name that was replaced or removed, sending an error event if it fails
+func (p *Pluginator) retire(name string, pc *PluginContent) {
+
+	if err := p.shutdown(name, pc); err != nil {
+		p.notifyError(name, p.plugins.active(name), err)
+	}
+}
+
+// shutdownAll shuts down all the active plugins, in name order
+func (p *Pluginator) shutdownAll() []error {
+
+	plugins := p.plugins.snapshot()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		if err := p.shutdown(name, plugins[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// hookTypes are the types lifecycle hooks must have
+var hookTypes = map[string]reflect.Type{
+	InitHook:        reflect.TypeOf(func(interface{}) error { return nil }),
+	ShutdownHook:    reflect.TypeOf(func() error { return nil }),
+	ExportStateHook: reflect.TypeOf(func() (interface{}, error) { return nil, nil }),
+	ImportStateHook: reflect.TypeOf(func(interface{}) error { return nil }),
+}
+
+func hasHook(pc *PluginContent, hook string) bool {
+
+	if _, isRemote := outOfProcess(pc.Lib); isRemote {
+		return false
+	}
+	_, err := pc.Lib.Lookup(hook)
+	return err == nil
+}
+
+/*
+callHook calls hook through run, if pc runs in the host and exports it. A hook that fails, panics, has the wrong type or does not return within
+the hook timeout yields a *LifecycleError; a hook that times out is left running.
+*/
+func (p *Pluginator) callHook(name string, pc *PluginContent, hook string, run func(hook interface{}) error) error {
+
+	if _, isRemote := outOfProcess(pc.Lib); isRemote {
+		return nil
+	}
+	sym, err := pc.Lib.Lookup(hook)
+	if err != nil {
+		return nil
+	}
+	lifecycleErr := &LifecycleError{
+		Plugin: name,
+		Hook:   hook,
+	}
+	if symType := reflect.TypeOf(sym); symType != hookTypes[hook] {
+		lifecycleErr.Err = fmt.Errorf("%s is %s, not %s", hook, symType, hookTypes[hook])
+		return lifecycleErr
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- run(sym)
+	}()
+	timer := time.NewTimer(p.hookTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		err = errors.New("timed out after " + p.hookTimeout.String())
+	}
+	if err == nil {
+		return nil
+	}
+	lifecycleErr.Err = err
+	return lifecycleErr
+}
@@ -0,0 +1,166 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHost records the lifecycle hooks called by plugins
+type testHost struct {
+	mutex sync.Mutex
+	calls []string
+}
+
+func (h *testHost) Record(call string) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.calls = append(h.calls, call)
+}
+
+func (h *testHost) Calls() string {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return strings.Join(h.calls, ",")
+}
+
+// lifecyclePlugin returns the code of a plugin with all lifecycle hooks, whose Init runs init
+func lifecyclePlugin(version, init string) string {
+	return `package main
+
+type recorder interface {
+	Record(string)
+}
+
+var host recorder
+
+var count int
+
+func Init(h interface{}) error {
+	host = h.(recorder)
+	host.Record("Init ` + version + `")
+	` + init + `
+	return nil
+}
+
+func Shutdown() error {
+	host.Record("Shutdown ` + version + `")
+	return nil
+}
+
+func ExportState() (interface{}, error) {
+	return count, nil
+}
+
+func ImportState(state interface{}) error {
+	count = state.(int) + 1
+	return nil
+}
+
+func Count() int {
+	return count
+}
+`
+}
+
+func TestLifecycle(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = createTestFile(tempPluginDir+"/counter.go", lifecyclePlugin("v1", "count = 41"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = createTestFile(tempPluginDir+"/other.go", lifecyclePlugin("other", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := &testHost{}
+	pluginator, err := NewPluginatorF(tempPluginDir, WithHost(host), WithHookTimeout(500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := nextEvent(t, events)
+	if event.Kind != EventScan || host.Calls() != "Init v1,Init other" {
+		t.Fatal("Should be able to initialize plugins with the host, got ", host.Calls())
+	}
+
+	err = replaceTestFile(tempPluginDir+"/counter.go", lifecyclePlugin("v2", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate {
+		t.Fatal("Should be able to receive an update event")
+	}
+	countPtr, err := event.New.Lib.Lookup("Count")
+	if err != nil {
+		t.Fatal("Should be able to lookup a symbol")
+	}
+	if countPtr.(func() int)() != 42 {
+		t.Fatal("Should be able to hand the state of a plugin over to its new version")
+	}
+	if !waitFor(func() bool { return strings.HasSuffix(host.Calls(), ",Init v2,Shutdown v1") }) {
+		t.Fatal("Should be able to shut down a replaced version, got ", host.Calls())
+	}
+
+	err = replaceTestFile(tempPluginDir+"/counter.go", strings.Replace(lifecyclePlugin("v3", `return errors.New("not today")`), "package main\n", "package main\n\nimport \"errors\"\n", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	le, ok := event.Err.(*LifecycleError)
+	if event.Kind != EventError || !ok || le.Hook != InitHook || le.Err.Error() != "not today" {
+		t.Fatal("Should be able to report a failing Init")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/counter.go", strings.Replace(lifecyclePlugin("v4", "time.Sleep(time.Minute)"), "package main\n", "package main\n\nimport \"time\"\n", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	le, ok = event.Err.(*LifecycleError)
+	if event.Kind != EventError || !ok || !strings.HasPrefix(le.Err.Error(), "timed out") {
+		t.Fatal("Should be able to time out a hook")
+	}
+	if active, _ := pluginator.Get("counter"); active != event.Old {
+		t.Fatal("Should be able to keep the previous version when Init fails")
+	}
+
+	err = deleteTestFile(tempPluginDir + "/counter.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventRemove || !waitFor(func() bool { return strings.HasSuffix(host.Calls(), ",Shutdown v2") }) {
+		t.Fatal("Should be able to shut down a removed plugin, got ", host.Calls())
+	}
+
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(host.Calls(), ",Shutdown other") {
+		t.Fatal("Should be able to shut down the active plugins on termination, got ", host.Calls())
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"plugin"
+	"strings"
+)
+
+// Symbols are what a loaded plugin exports, looked up by name. *plugin.Plugin is Symbols.
+type Symbols interface {
+	Lookup(symName string) (plugin.Symbol, error)
+}
+
+/*
+RemoteSymbols are Symbols of a plugin running outside the host, like *Process, if OutOfProcess says so. They are closed
+when their version is replaced, removed or terminated, instead of having their lifecycle hooks called.
+*/
+type RemoteSymbols interface {
+	Symbols
+	io.Closer
+	OutOfProcess() bool
+}
+
+// outOfProcess returns lib as RemoteSymbols if it runs outside the host
+func outOfProcess(lib Symbols) (RemoteSymbols, bool) {
+
+	remote, isRemote := lib.(RemoteSymbols)
+	return remote, isRemote && remote.OutOfProcess()
+}
+
+/*
+Builder compiles the source of plugins. versionedName is unique to the source and the build settings, so that builds can
+be cached under it. The artifact returned is what a Loader loads, e.g. the path of a library. ctx is done when the build
+is no longer needed, as the plugin changed again or the Pluginator is terminated.
+*/
+type Builder interface {
+	Build(ctx context.Context, sp SourcePlugin, versionedName string) (artifact string, err error)
+}
+
+// APIReader is implemented by Builders that can tell the API of the plugins they build, once they are built
+type APIReader interface {
+	// API returns the API of plugin sp built under versionedName, or nil if it cannot be read
+	API(ctx context.Context, sp SourcePlugin, versionedName string) *API
+}
+
+// Loader loads the artifacts built by a Builder, returning the Symbols of plugin name
+type Loader interface {
+	Load(name, artifact string) (Symbols, error)
+}
+
+// goBuilder builds plugins with the go tool into the cache, as libraries or, with ProcessRuntime, executables
+type goBuilder struct {
+	p *Pluginator
+}
+
+func (b goBuilder) Build(ctx context.Context, sp SourcePlugin, versionedName string) (string, error) {
+
+	p := b.p
+	artifact := p.cacheDir + "/" + versionedName + ".so"
+	if p.runtime == ProcessRuntime {
+		artifact = p.cacheDir + "/" + versionedName + ".bin"
+	}
+	_, err := os.Stat(artifact)
+	switch {
+	case os.IsNotExist(err):
+		err = p.compile(ctx, sp, versionedName, artifact)
+	case err == nil:
+		log.Println("Found ", strings.TrimPrefix(artifact, p.cacheDir+"/")+" in cache")
+	}
+	return artifact, err
+}
+
+// API reads the API of a plugin from its source, with go/types
+func (b goBuilder) API(ctx context.Context, sp SourcePlugin, versionedName string) *API {
+	return b.p.api(ctx, sp, versionedName)
+}
+
+// libraryLoader loads plugins into the host with plugin.Open
+type libraryLoader struct {
+	p *Pluginator
+}
+
+func (l libraryLoader) Load(name, artifact string) (Symbols, error) {
+
+	pluginLib, err := plugin.Open(artifact)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Loaded ", strings.TrimPrefix(artifact, l.p.cacheDir+"/"))
+	return pluginLib, nil
+}
+
+// processLoader runs plugins in their own process
+type processLoader struct {
+	p *Pluginator
+}
+
+func (l processLoader) Load(name, artifact string) (Symbols, error) {
+
+	process, err := l.p.startProcess(name, artifact)
+	if err != nil {
+		return nil, err
+	}
+	return process, nil
+}
@@ -0,0 +1,228 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"plugin"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeBuilder "builds" plugins whose code is made of symbol=value lines, passing the code on as the artifact
+type fakeBuilder struct{}
+
+func (fakeBuilder) Build(ctx context.Context, sp SourcePlugin, versionedName string) (string, error) {
+
+	if strings.Contains(sp.Code, "broken") {
+		return "", errors.New("cannot build " + versionedName)
+	}
+	return sp.Code, nil
+}
+
+// fakeAPIBuilder is a fakeBuilder telling the API of plugins: their symbols are string variables
+type fakeAPIBuilder struct {
+	fakeBuilder
+}
+
+func (fakeAPIBuilder) API(ctx context.Context, sp SourcePlugin, versionedName string) *API {
+
+	api := &API{}
+	for _, line := range strings.Split(strings.TrimSpace(sp.Code), "\n") {
+		if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+			api.Vars = append(api.Vars, Export{Name: parts[0], Type: "string"})
+		}
+	}
+	return api
+}
+
+// fakeLoader "loads" the symbols of the code passed on by fakeBuilder
+type fakeLoader struct{}
+
+type fakeSymbols map[string]plugin.Symbol
+
+func (fakeLoader) Load(name, artifact string) (Symbols, error) {
+
+	symbols := fakeSymbols{}
+	for _, line := range strings.Split(strings.TrimSpace(artifact), "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			symbols[parts[0]] = parts[1]
+		}
+	}
+	return symbols, nil
+}
+
+func (s fakeSymbols) Lookup(symName string) (plugin.Symbol, error) {
+
+	sym, exists := s[symName]
+	if !exists {
+		return nil, errors.New("no symbol " + symName)
+	}
+	return sym, nil
+}
+
+func TestFakeRuntime(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = createTestFile(tempPluginDir+"/greeter.go", "Greeting=hello\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pluginator, err := NewPluginatorF(tempPluginDir, WithBuilder(fakeBuilder{}), WithLoader(fakeLoader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	greetings := Bind[string](pluginator, "Greeting")
+	defer greetings.Close()
+
+	event := nextEvent(t, events)
+	if event.Kind != EventScan || event.Plugins["greeter"] == nil || event.Plugins["greeter"].API != nil {
+		t.Fatal("Should be able to load a plugin with a custom builder and loader")
+	}
+	if greeting, _ := greetings.Get("greeter"); greeting != "hello" {
+		t.Fatal("Should be able to bind the symbols of a custom loader")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/greeter.go", "Greeting=ciao\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || !waitFor(func() bool {
+		greeting, _ := greetings.Get("greeter")
+		return greeting == "ciao"
+	}) {
+		t.Fatal("Should be able to update a plugin with a custom builder and loader")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/greeter.go", "broken\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventError || event.Err.Error() != "cannot build greeter-"+pluginator.cacheKey(SourcePlugin{Name: "greeter", Code: "broken\n"}) {
+		t.Fatal("Should be able to report errors of a custom builder, got ", event.Err)
+	}
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIReader(t *testing.T) {
+
+	source := newFakeSource(SourcePlugin{Name: "greeter", Code: "Greeting=hello\n"})
+	pluginator, err := NewPluginator(source, WithBuilder(fakeAPIBuilder{}), WithLoader(fakeLoader{}), WithQuietPeriod(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	event := nextEvent(t, events)
+	greeter := event.Plugins["greeter"]
+	if event.Kind != EventScan || greeter == nil || greeter.API == nil || len(greeter.API.Vars) != 1 || greeter.API.Vars[0].Name != "Greeting" {
+		t.Fatal("Should be able to read the API of plugins with a custom builder")
+	}
+	source.changes <- SourceEvent{Action: SourceUpdate, Plugin: SourcePlugin{Name: "greeter", Code: "Greeting=ciao\nName=pluginator\n"}}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || event.Diff == nil || len(event.Diff.Added) != 1 || event.Diff.Breaking() {
+		t.Fatal("Should be able to diff the API of plugins with a custom builder")
+	}
+}
+
+// closableSymbols are symbols with a Shutdown hook and a Close method, counting their calls
+type closableSymbols struct {
+	fakeSymbols
+	closes *int32
+}
+
+func (s closableSymbols) Close() error {
+	atomic.AddInt32(s.closes, 1)
+	return nil
+}
+
+// remoteSymbols are closableSymbols running outside the host
+type remoteSymbols struct {
+	closableSymbols
+}
+
+func (remoteSymbols) OutOfProcess() bool {
+	return true
+}
+
+// closableLoader loads closableSymbols, or remoteSymbols if remote
+type closableLoader struct {
+	remote    bool
+	shutdowns *int32
+	closes    *int32
+}
+
+func (l closableLoader) Load(name, artifact string) (Symbols, error) {
+
+	symbols := closableSymbols{
+		fakeSymbols: fakeSymbols{"Shutdown": func() error {
+			atomic.AddInt32(l.shutdowns, 1)
+			return nil
+		}},
+		closes: l.closes,
+	}
+	if l.remote {
+		return remoteSymbols{symbols}, nil
+	}
+	return symbols, nil
+}
+
+func TestRemoteSymbols(t *testing.T) {
+
+	for _, remote := range []bool{false, true} {
+		var shutdowns, closes int32
+		source := newFakeSource(SourcePlugin{Name: "greeter", Code: "Greeting=hello\n"})
+		pluginator, err := NewPluginator(source, WithBuilder(fakeBuilder{}), WithLoader(closableLoader{remote, &shutdowns, &closes}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		events := pluginator.Events(context.Background())
+		err = pluginator.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event := nextEvent(t, events); event.Kind != EventScan || event.Plugins["greeter"] == nil {
+			t.Fatal("Should be able to receive a scan event")
+		}
+		source.changes <- SourceEvent{Action: SourceRemove, Plugin: SourcePlugin{Name: "greeter"}}
+		if event := nextEvent(t, events); event.Kind != EventRemove {
+			t.Fatal("Should be able to receive a remove event")
+		}
+		err = pluginator.Terminate(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remote && (atomic.LoadInt32(&closes) != 1 || atomic.LoadInt32(&shutdowns) != 0) {
+			t.Fatal("Should be able to close symbols running outside the host, instead of calling their hooks")
+		}
+		if !remote && (atomic.LoadInt32(&closes) != 0 || atomic.LoadInt32(&shutdowns) != 1) {
+			t.Fatal("Should be able to call the hooks of symbols running in the host, even if they can be closed")
+		}
+	}
+}
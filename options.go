@@ -0,0 +1,177 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import "time"
+
+// Option configures a Pluginator at creation time
+type Option func(*Pluginator)
+
+// WithCacheDir makes compiled plugins persist in dir, where they are keyed by a hash of their source code, the go
+// toolchain version and the build flags. Unchanged plugins are loaded from dir instead of being rebuilt, also across
+// restarts. By default a temporary directory is used.
+func WithCacheDir(dir string) Option {
+	return func(p *Pluginator) {
+		p.cacheDir = dir
+	}
+}
+
+// WithMaxParallelBuilds limits to n the number of plugins compiled at the same time. The default is the number of CPUs.
+func WithMaxParallelBuilds(n int) Option {
+	return func(p *Pluginator) {
+		if n < 1 {
+			n = 1
+		}
+		p.buildSlots = make(chan struct{}, n)
+	}
+}
+
+// WithTempDirCleanup makes Terminate remove the temporary directory used as build cache when no cache dir is configured
+func WithTempDirCleanup() Option {
+	return func(p *Pluginator) {
+		p.cleanTempDir = true
+	}
+}
+
+// WithGoFlags sets GOFLAGS for the go tool when building plugins, e.g. "-tags=prod"
+func WithGoFlags(goFlags string) Option {
+	return withBuildEnv("GOFLAGS", goFlags)
+}
+
+// WithGoProxy sets GOPROXY for the go tool when building plugins. "off" makes builds only use the module cache.
+func WithGoProxy(goProxy string) Option {
+	return withBuildEnv("GOPROXY", goProxy)
+}
+
+// WithGoNoSumDB sets GONOSUMDB, the patterns of module paths not to be checked against the checksum database
+func WithGoNoSumDB(patterns string) Option {
+	return withBuildEnv("GONOSUMDB", patterns)
+}
+
+/*
+WithVendorDir makes plugin packages having their own go.mod, but no vendor directory, take their dependencies from dir,
+a vendor directory (with a modules.txt) consistent with their requirements. dir is read once, when the Pluginator is
+created.
+*/
+func WithVendorDir(dir string) Option {
+	return func(p *Pluginator) {
+		p.vendorDir = dir
+	}
+}
+
+// WithGoBinary makes plugins build with the go tool at path, instead of the one in PATH
+func WithGoBinary(path string) Option {
+	return func(p *Pluginator) {
+		p.goBinary = path
+	}
+}
+
+// WithGoRoot makes plugins build with the go installation in dir, instead of the one in PATH
+func WithGoRoot(dir string) Option {
+	return func(p *Pluginator) {
+		p.goBinary = dir + "/bin/go"
+		p.buildEnv = append(p.buildEnv, "GOROOT="+dir)
+	}
+}
+
+// WithContract makes plugins be activated only if they satisfy contract. Those that do not are reported as errors.
+func WithContract(contract Contract) Option {
+	return func(p *Pluginator) {
+		p.contract = &contract
+	}
+}
+
+// WithAPIPolicy tells what to do with updates changing the exported API of a plugin (AllowBreakingChanges by default)
+func WithAPIPolicy(policy APIPolicy) Option {
+	return func(p *Pluginator) {
+		p.apiPolicy = policy
+	}
+}
+
+// WithRuntime sets how plugins are run (InProcessRuntime by default)
+func WithRuntime(runtime Runtime) Option {
+	return func(p *Pluginator) {
+		p.runtime = runtime
+	}
+}
+
+// WithBuilder makes plugins build with builder, instead of the go tool
+func WithBuilder(builder Builder) Option {
+	return func(p *Pluginator) {
+		p.builder = builder
+	}
+}
+
+// WithLoader makes plugins load with loader, instead of the one of the runtime
+func WithLoader(loader Loader) Option {
+	return func(p *Pluginator) {
+		p.loader = loader
+	}
+}
+
+// WithGrowthPolicy sets limits to the growth of the host, caused by plugins loaded into it, beyond which a restart is
+// recommended
+func WithGrowthPolicy(policy GrowthPolicy) Option {
+	return func(p *Pluginator) {
+		p.growthPolicy = &policy
+	}
+}
+
+/*
+WithReexec makes the host re-execute itself, with Reexec, when the growth policy recommends a restart, then call drain,
+which should stop accepting on the listeners obtained with Listen, drain the work in flight, terminate the Pluginator and
+exit. If the host cannot re-execute, error subscribers get a *ReexecError, and it keeps serving until the next load that
+exceeds the growth policy recommends a restart again.
+*/
+func WithReexec(drain func()) Option {
+	return func(p *Pluginator) {
+		p.drain = drain
+	}
+}
+
+/*
+WithQuietPeriod sets how long a plugin must go without changes before it is built (DefaultQuietPeriod by default), so that
+a burst of changes, like the writes of an editor saving a file, leads to one build. Zero builds on every change.
+*/
+func WithQuietPeriod(period time.Duration) Option {
+	return func(p *Pluginator) {
+		p.quietPeriod = period
+	}
+}
+
+// WithConsulPollInterval sets the fallback poll interval of the consul source created by NewPluginatorC
+// (DefaultConsulPollInterval by default). It has no effect on other sources.
+func WithConsulPollInterval(interval time.Duration) Option {
+	return func(p *Pluginator) {
+		p.pollInterval = interval
+	}
+}
+
+// WithHost sets the value passed to the Init hook of plugins, typically an interface to the host's services
+func WithHost(host interface{}) Option {
+	return func(p *Pluginator) {
+		p.host = host
+	}
+}
+
+// WithHookTimeout sets how long lifecycle hooks of plugins and validators can run (DefaultHookTimeout by default)
+func WithHookTimeout(timeout time.Duration) Option {
+	return func(p *Pluginator) {
+		p.hookTimeout = timeout
+	}
+}
+
+// WithProcessTimeout sets how long processes of plugins have to start and to stop, with ProcessRuntime
+// (DefaultProcessTimeout by default)
+func WithProcessTimeout(timeout time.Duration) Option {
+	return func(p *Pluginator) {
+		p.processTimeout = timeout
+	}
+}
+
+func withBuildEnv(key, value string) Option {
+	return func(p *Pluginator) {
+		p.buildEnv = append(p.buildEnv, key+"="+value)
+	}
+}
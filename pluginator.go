@@ -5,409 +5,565 @@
 package pluginator
 
 import (
-	"bytes"
+	"context"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
-	"plugin"
-	"strconv"
-	"strings"
-
-	"github.com/fsnotify/fsnotify"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// PluginContent is sent on pluginator events. It contains the actual library that was loaded and its source code.
+// DefaultQuietPeriod is how long a plugin must go without changes before it is built, unless WithQuietPeriod says otherwise
+const DefaultQuietPeriod = 100 * time.Millisecond
+
+// PluginContent is sent on pluginator events. It contains the symbols of the plugin that was loaded (the library, or the
+// *Process running it with ProcessRuntime) and its source code: Code for single-file plugins, Files for plugin packages.
 type PluginContent struct {
-	Lib  *plugin.Plugin
-	Code string
+	Lib   Symbols
+	Code  string
+	Files map[string]string
+	// API is what the plugin exports, read from its source; nil if it could not be read
+	API *API
 }
 
 // Pluginator is lib's entry point
 type Pluginator struct {
-	pluginDir         string
-	watcher           *fsnotify.Watcher
-	tempDir           string
-	plugins           map[string]*PluginContent
-	scanSubscribers   []func(map[string]*PluginContent)
-	updateSubscribers []func(string, *PluginContent)
-	removeSubscribers []func(string, *PluginContent)
-	addSubscribers    []func(string, *PluginContent)
-	consulWatcher     *consulWatcher
-	consulHost        string
-	consulPort        int
-	consulKeyPrefix   string
+	source   Source
+	tempDir  string
+	cacheDir string
+	// goBinary is the go tool, goVersion its version, OS and architecture, goLang the language version of the go.mod files
+	// written for plugins
+	goBinary   string
+	goVersion  string
+	goLang     string
+	buildFlags []string
+	// buildEnv are the environment variables, in KEY=value form, set for the go tool
+	buildEnv []string
+	// vendorFiles is the content of the vendor directory shared by plugin modules, vendorKey its fingerprint
+	vendorDir     string
+	vendorFiles   map[string]string
+	vendorKey     string
+	hostModules   hostModules
+	contract      *Contract
+	apiPolicy     APIPolicy
+	runtime       Runtime
+	builder       Builder
+	loader        Loader
+	validators    validators
+	growthPolicy  *GrowthPolicy
+	growth        growth
+	host          interface{}
+	hookTimeout   time.Duration
+	quietPeriod   time.Duration
+	pollInterval  time.Duration
+	buildSlots    chan struct{}
+	buildMutex    sync.Mutex
+	building      map[string]*buildJob
+	plugins       *registry
+	subscriptions subscriptions
+	// ctx ends when the Pluginator is terminated, buildCtx when in-flight builds must be killed. stopped is closed when
+	// the watch started by Start is over; startMutex guards it, and terminated.
+	ctx          context.Context
+	cancel       context.CancelFunc
+	buildCtx     context.Context
+	cancelBuilds context.CancelFunc
+	startMutex   sync.Mutex
+	stopped      chan struct{}
+	terminated   bool
+	cleanTempDir bool
+	// handedOver is set when the temporary build cache was handed over to a re-executed host, and must be kept
+	handedOver int32
+	// processTimeout is how long processes of plugins have to start and stop
+	processTimeout time.Duration
+	// drain is called after the host re-executes as the growth policy is exceeded
+	drain          func()
+	terminateOnce  sync.Once
+	terminateError error
 }
 
-// NewPluginatorC instantiates a new Pluginator, watching the subkeys of keyPrefix on the host:port consul instance
-func NewPluginatorC(host string, port int, keyPrefix string) (*Pluginator, error) {
+// NewPluginator instantiates a new Pluginator, loading plugins from source
+func NewPluginator(source Source, options ...Option) (*Pluginator, error) {
 
-	err := checkGoToolchain()
-	if err != nil {
+	return newPluginator(func(*Pluginator) (Source, error) {
+		return source, nil
+	}, options...)
+}
+
+// newPluginator instantiates a new Pluginator, loading plugins from the source newSource creates once options are applied
+func newPluginator(newSource func(p *Pluginator) (Source, error), options ...Option) (*Pluginator, error) {
+
+	p := &Pluginator{
+		goBinary:    "go",
+		hostModules: readHostModules(),
+		plugins:     newRegistry(),
+		subscriptions: subscriptions{
+			open: make(map[*subscription]struct{}),
+		},
+		building:       make(map[string]*buildJob),
+		hookTimeout:    DefaultHookTimeout,
+		processTimeout: DefaultProcessTimeout,
+		quietPeriod:    DefaultQuietPeriod,
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.buildCtx, p.cancelBuilds = context.WithCancel(context.Background())
+	for _, option := range options {
+		option(p)
+	}
+	var err error
+	if p.source, err = newSource(p); err != nil {
 		return nil, err
 	}
-
-	PluginDir, err := ioutil.TempDir("", "pluginator-consul")
+	if p.buildSlots == nil {
+		p.buildSlots = make(chan struct{}, runtime.NumCPU())
+	}
+	if p.builder == nil {
+		p.builder = goBuilder{p}
+	}
+	if p.runtime == InProcessRuntime {
+		p.buildFlags = []string{"-buildmode=plugin"}
+		if p.loader == nil {
+			p.loader = libraryLoader{p}
+		}
+	} else {
+		if p.contract != nil {
+			return nil, errors.New("contracts need plugins loaded in the host, they do not apply to ProcessRuntime")
+		}
+		if p.loader == nil {
+			p.loader = processLoader{p}
+		}
+	}
+	err = p.probeToolchain()
 	if err != nil {
 		return nil, err
 	}
-	p := &Pluginator{
-		pluginDir:       PluginDir,
-		consulHost:      host,
-		consulPort:      port,
-		consulKeyPrefix: keyPrefix,
-		plugins:         make(map[string]*PluginContent),
+	if p.vendorDir != "" {
+		p.vendorFiles, err = readTree(p.vendorDir)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := p.vendorFiles["modules.txt"]; !exists {
+			return nil, errors.New(p.vendorDir + " is not a vendor directory, it has no modules.txt")
+		}
+		p.vendorKey = SourcePlugin{Files: p.vendorFiles}.fingerprint()
 	}
 
-	p.tempDir, err = ioutil.TempDir("", "pluginator")
-	if err != nil {
+	if p.cacheDir == "" {
+		p.tempDir = inheritedCacheDir()
+		if p.tempDir == "" {
+			p.tempDir, err = ioutil.TempDir("", "pluginator")
+			if err != nil {
+				return nil, err
+			}
+		}
+		p.cacheDir = p.tempDir
+	} else if err = os.MkdirAll(p.cacheDir, 0755); err != nil {
 		return nil, err
 	}
 	return p, nil
-
 }
 
-// NewPluginatorF instantiates a new Pluginator, watching the PluginDir diretory
-func NewPluginatorF(PluginDir string) (*Pluginator, error) {
+// NewPluginatorC instantiates a new Pluginator, watching the subkeys of keyPrefix on the host:port consul instance
+func NewPluginatorC(host string, port int, keyPrefix string, options ...Option) (*Pluginator, error) {
 
-	err := checkGoToolchain()
+	var source Source
+	p, err := newPluginator(func(p *Pluginator) (Source, error) {
+		var err error
+		source, err = NewConsulSource(host, port, keyPrefix, p.pollInterval)
+		return source, err
+	}, options...)
 	if err != nil {
+		if source != nil {
+			source.Close()
+		}
 		return nil, err
 	}
+	return p, nil
+}
 
-	if strings.HasSuffix(PluginDir, "/") {
-		return nil, errors.New("Plugin dir must not end with /")
-	}
+// NewPluginatorF instantiates a new Pluginator, watching the PluginDir diretory
+func NewPluginatorF(PluginDir string, options ...Option) (*Pluginator, error) {
 
-	f, err := os.Stat(PluginDir)
+	source, err := NewDirSource(PluginDir)
 	if err != nil {
 		return nil, err
 	}
-	if !f.Mode().IsDir() {
-		return nil, errors.New(PluginDir + " is not a directory")
-	}
-
-	p := &Pluginator{
-		pluginDir: PluginDir,
-		plugins:   make(map[string]*PluginContent),
-	}
-
-	p.tempDir, err = ioutil.TempDir("", "pluginator")
+	p, err := NewPluginator(source, options...)
 	if err != nil {
+		source.Close()
 		return nil, err
 	}
 	return p, nil
 }
 
-func checkGoToolchain() error {
-	command := exec.Command("go", "version")
-
-	out, err := command.Output()
-	if err != nil {
-		return err
-	}
-	outSplit := strings.Split(string(out), " ")
-	if len(outSplit) < 4 {
-		return errors.New("cannot parse output from go version")
-	}
+// Start start a Pluginator. It will perform a scan of its source. It can be called once, and not after Terminate.
+func (p *Pluginator) Start() error {
 
-	versionSplit := strings.Split(outSplit[2], ".")
-	majV := []rune(versionSplit[0])[2]
-	majVI, err := strconv.Atoi(string(majV))
-	if err != nil {
-		return err
+	p.startMutex.Lock()
+	if p.stopped != nil || p.terminated {
+		p.startMutex.Unlock()
+		return errors.New("the Pluginator was already started or terminated")
 	}
+	stopped := make(chan struct{})
+	p.stopped = stopped
+	p.startMutex.Unlock()
 
-	minV := versionSplit[1]
-	minVI, err := strconv.Atoi(minV)
+	log.Println("Watching ", p.source)
+	plugins, err := p.source.List()
 	if err != nil {
+		close(stopped)
 		return err
 	}
-
-	osVers := outSplit[3]
-	if majVI < 1 || minVI < 8 || !strings.HasPrefix(osVers, "linux") {
-		return errors.New("Bad go version - need 1.8 or higher on linux")
+	latest := make(map[string]string, len(plugins))
+	for _, sp := range plugins {
+		latest[sp.Name] = sp.fingerprint()
 	}
-
+	p.scan(plugins)
+	go p.watch(latest, stopped)
 	return nil
 }
 
-// SubscribeScan subscribes its argument to scan events (they happen at start time)
-func (p *Pluginator) SubscribeScan(f func(map[string]*PluginContent)) {
-
-	p.scanSubscribers = append(p.scanSubscribers, f)
-}
-
-// SubscribeUpdate subscribe its argument to update events (changes in plugin code)
-func (p *Pluginator) SubscribeUpdate(f func(string, *PluginContent)) {
-	p.updateSubscribers = append(p.updateSubscribers, f)
-}
-
-// SubscribeRemove subscribes its argument to remove events (plugin removal)
-func (p *Pluginator) SubscribeRemove(f func(string, *PluginContent)) {
-	p.removeSubscribers = append(p.removeSubscribers, f)
-}
+/*
+Terminate makes a Pluginator stop watching its source. It waits for in-flight builds to complete, or kills them if ctx
+is done first, stops all goroutines and closes all Events channels. It can be called more than once, always returning
+the result of the first call.
+*/
+func (p *Pluginator) Terminate(ctx context.Context) error {
 
-// SubscribeAdd subscribes its argument to add events (plugin adds)
-func (p *Pluginator) SubscribeAdd(f func(string, *PluginContent)) {
-	p.addSubscribers = append(p.addSubscribers, f)
+	p.terminateOnce.Do(func() {
+		p.terminateError = p.terminate(ctx)
+	})
+	return p.terminateError
 }
 
-// Start start a Pluginator. It will perform a scan of the watched dir/consul key
-func (p *Pluginator) Start() error {
-	var msg string
-	if p.consulHost != "" {
-		msg = p.consulHost + ":" + strconv.Itoa(p.consulPort) + ":" + p.consulKeyPrefix
-	} else {
-		msg = p.pluginDir
-	}
-	log.Println("Watching ", msg)
-	if p.consulHost != "" {
-		var err error
-		p.consulWatcher, err = p.watchConsul()
-		if err != nil {
-			return err
+func (p *Pluginator) terminate(ctx context.Context) error {
+
+	log.Println("Terminating ", p.source)
+	p.startMutex.Lock()
+	p.terminated = true
+	stopped := p.stopped
+	p.startMutex.Unlock()
+	var errs []error
+	p.cancel()
+	if err := p.source.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if stopped != nil {
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			p.cancelBuilds()
+			<-stopped
 		}
 	}
+	p.cancelBuilds()
+	errs = append(errs, p.shutdownAll()...)
 
-	var err error
-	p.watcher, err = p.watch(p.pluginDir)
-	if err != nil {
-		return err
+	if p.cleanTempDir && p.tempDir != "" && atomic.LoadInt32(&p.handedOver) == 0 {
+		if err := os.RemoveAll(p.tempDir); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	p.scan()
-	return nil
+	return errors.Join(errs...)
 }
 
-// Terminate makes a Pluginator stop watching a directory/consul key
-func (p *Pluginator) Terminate() {
-	if p.consulWatcher != nil {
-		p.consulWatcher.Terminate()
-	}
-	err := p.watcher.Close()
-	if err != nil {
-		log.Println(err)
+/*
+watch dispatches the changes coming from the source once each plugin is quiet, skipping those that do not change the
+code of a plugin. Builds run in parallel, while their results are applied, and subscribers notified, by deliver in the
+order the changes were dispatched. A change makes the job of the previous one stale. When the source closes, unless the
+Pluginator is terminated, the changes still waiting for their plugin to be quiet are dispatched at once.
+*/
+func (p *Pluginator) watch(latest map[string]string, stopped chan<- struct{}) {
+
+	jobs := make(chan *buildJob, maxQueuedJobs)
+	last := &lastJobs{jobs: make(map[string]*buildJob)}
+	go p.deliver(jobs, last, stopped)
+	defer close(jobs)
+	pending := make(map[string]*pendingChange)
+	quiet := make(chan *pendingChange)
+	changes := p.source.Changes()
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				if p.ctx.Err() != nil {
+					// terminated, so their builds would be thrown away
+					return
+				}
+				names := make([]string, 0, len(pending))
+				for name := range pending {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					pending[name].timer.Stop()
+					p.dispatch(pending[name].event, latest, last, jobs)
+				}
+				return
+			}
+			if p.quietPeriod <= 0 {
+				p.dispatch(change, latest, last, jobs)
+				continue
+			}
+			p.debounce(pending, quiet, change)
+		case change := <-quiet:
+			if pending[change.event.Plugin.Name] != change {
+				// superseded by a later change, as its timer fired
+				continue
+			}
+			delete(pending, change.event.Plugin.Name)
+			p.dispatch(change.event, latest, last, jobs)
+		}
 	}
 }
 
-func (p *Pluginator) watch(fileName string) (*fsnotify.Watcher, error) {
-
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, err
-	}
+// dispatch starts the build of event, unless it does not change the code of its plugin, and queues its job for delivery
+func (p *Pluginator) dispatch(event SourceEvent, latest map[string]string, last *lastJobs, jobs chan<- *buildJob) {
 
-	go func() {
-		for {
-			select {
-			case event := <-watcher.Events:
-				switch event.Op {
-				case fsnotify.Write:
-					fileInfo, err := os.Lstat(event.Name)
-					if err != nil {
-						log.Println(err)
-						break
-					}
-					if !p.isCompileUnit(fileInfo) {
-						break
-					}
-					log.Println("Reloading ", fileInfo.Name())
-					name, pluginLib, err := p.processPlugin(fileInfo)
-					if err != nil {
-						log.Println(err)
-						break
-					}
-					for _, subscriber := range p.updateSubscribers {
-						subscriber(name, pluginLib)
-					}
-				case fsnotify.Create:
-					fileInfo, err := os.Lstat(event.Name)
-					if err != nil {
-						log.Println(err)
-						break
-					}
-					if !p.isCompileUnit(fileInfo) {
-						break
-					}
-					log.Println("Discovered ", fileInfo.Name())
-					name, pluginLib, err := p.processPlugin(fileInfo)
-					if err != nil {
-						log.Println(err)
-						break
-					}
-					for _, subscriber := range p.addSubscribers {
-						subscriber(name, pluginLib)
-					}
-				case fsnotify.Rename:
-					fallthrough
-				case fsnotify.Remove:
-					baseName := strings.TrimPrefix(event.Name, p.pluginDir+"/")
-					baseName = strings.TrimSuffix(baseName, ".go")
-					if pluginLib, exists := p.plugins[baseName]; exists {
-						for _, subscriber := range p.removeSubscribers {
-							subscriber(baseName, pluginLib)
-						}
-						delete(p.plugins, baseName)
-					}
-					log.Println("Removed ", baseName)
-				}
-			case err := <-watcher.Errors:
-				log.Println("error:", err)
-			}
+	name := event.Plugin.Name
+	switch event.Action {
+	case SourceAdd, SourceUpdate:
+		fingerprint := event.Plugin.fingerprint()
+		previous, exists := latest[name]
+		if exists && previous == fingerprint {
+			return
 		}
-	}()
-
-	err = watcher.Add(fileName)
-	if err != nil {
-		return nil, err
+		if exists {
+			log.Println("Reloading ", name)
+		} else {
+			log.Println("Discovered ", name)
+		}
+		latest[name] = fingerprint
+	case SourceRemove:
+		delete(latest, name)
 	}
-	return watcher, nil
+	job := p.startBuild(event)
+	last.replace(name, job)
+	jobs <- job
 }
 
-/*
-scan will scan the whole plugin directory for .go files, compile them, load them and notify scan subscribers.
-*/
-func (p *Pluginator) scan() {
+// lastJobs are the jobs last dispatched for each plugin, until they are delivered
+type lastJobs struct {
+	mutex sync.Mutex
+	jobs  map[string]*buildJob
+}
 
-	files, err := ioutil.ReadDir(p.pluginDir)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	for _, file := range files {
-		if p.isCompileUnit(file) {
-			log.Println("Discovered ", file.Name())
-			_, _, err = p.processPlugin(file)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-		}
+// replace makes job the last job of plugin name, making the previous one stale
+func (lj *lastJobs) replace(name string, job *buildJob) {
 
+	lj.mutex.Lock()
+	defer lj.mutex.Unlock()
+	if lastJob, exists := lj.jobs[name]; exists {
+		lastJob.cancel()
 	}
+	lj.jobs[name] = job
+}
+
+// delivered forgets job, unless a later job of its plugin replaced it
+func (lj *lastJobs) delivered(job *buildJob) {
 
-	for _, scanSubscriber := range p.scanSubscribers {
-		scanSubscriber(p.plugins)
+	lj.mutex.Lock()
+	defer lj.mutex.Unlock()
+	if lj.jobs[job.event.Plugin.Name] == job {
+		delete(lj.jobs, job.event.Plugin.Name)
 	}
 }
 
-func (p *Pluginator) isCompileUnit(file os.FileInfo) bool {
-	return !file.IsDir() && strings.HasSuffix(file.Name(), ".go")
+// pendingChange is the last change of a plugin, waiting for the plugin to be quiet
+type pendingChange struct {
+	event SourceEvent
+	timer *time.Timer
 }
 
-func (p *Pluginator) processPlugin(file os.FileInfo) (string, *PluginContent, error) {
+// debounce makes change the pending change of its plugin, sent to quiet once no other change comes for the quiet period
+func (p *Pluginator) debounce(pending map[string]*pendingChange, quiet chan<- *pendingChange, change SourceEvent) {
 
-	var baseName string
-	var pluginLib *plugin.Plugin
-
-	baseName = strings.TrimSuffix(file.Name(), ".go")
-	var err error
-	pluginLib, err = p.compileAndLoad(baseName)
-	if err != nil {
-		return "", nil, err
-	}
-	code, err := ioutil.ReadFile(p.pluginDir + "/" + file.Name())
-	if err != nil {
-		return "", nil, err
+	name := change.Plugin.Name
+	if previous, exists := pending[name]; exists {
+		previous.timer.Stop()
 	}
-	pc := PluginContent{
-		Lib:  pluginLib,
-		Code: string(code),
-	}
-	p.plugins[baseName] = &pc
-	return baseName, &pc, nil
+	pc := &pendingChange{event: change}
+	pc.timer = time.AfterFunc(p.quietPeriod, func() {
+		select {
+		case quiet <- pc:
+		case <-p.ctx.Done():
+		}
+	})
+	pending[name] = pc
 }
 
-func (p *Pluginator) compileAndLoad(baseName string) (*plugin.Plugin, error) {
-
-	version, err := p.genVersionedName(baseName)
-	if err != nil {
-		return nil, err
+// deliver applies the results of jobs in order, then closes stopped. After termination, it only waits for pending builds.
+func (p *Pluginator) deliver(jobs <-chan *buildJob, last *lastJobs, stopped chan<- struct{}) {
+
+	defer close(stopped)
+	for job := range jobs {
+		<-job.done
+		stale := job.ctx.Err() != nil
+		job.cancel()
+		last.delivered(job)
+		if p.ctx.Err() != nil || stale {
+			continue
+		}
+		name := job.event.Plugin.Name
+		switch job.event.Action {
+		case SourceAdd, SourceUpdate:
+			pluginLib, previous, exceeded, err := p.activate(job)
+			if err != nil {
+				if exceeded {
+					p.recommendRestart(name)
+				}
+				break
+			}
+			event := Event{
+				Kind: EventAdd,
+				Name: name,
+				New:  pluginLib,
+			}
+			if previous != nil {
+				event.Kind = EventUpdate
+				event.Old = previous
+				event.Diff = diffAPI(previous.API, pluginLib.API)
+			}
+			p.emit(event)
+			if exceeded {
+				p.recommendRestart(name)
+			}
+			if previous != nil {
+				p.retire(name, previous)
+			}
+		case SourceRemove:
+			if pluginLib := p.plugins.remove(name); pluginLib != nil {
+				p.emit(Event{
+					Kind: EventRemove,
+					Name: name,
+					Old:  pluginLib,
+				})
+				p.retire(name, pluginLib)
+			}
+			log.Println("Removed ", name)
+		}
 	}
-	address := fmt.Sprintf("%p", &p)
-	pPath := "\"" + "-pluginpath=" + address + baseName + version + "\""
-	command := exec.Command("go", "build", "-ldflags", pPath, "-buildmode=plugin", "-o", p.tempDir+"/"+baseName+"."+version+".so", p.pluginDir+"/"+baseName+".go")
+}
 
-	var stdErr bytes.Buffer
-	command.Stderr = &stdErr
-	_, err = command.Output()
-	if err != nil {
-		return nil, errors.New(stdErr.String())
+/*
+scan will compile and load the plugins found in the source at start time, and notify scan subscribers. Plugins are
+built in parallel and loaded in name order.
+*/
+func (p *Pluginator) scan(plugins []SourcePlugin) {
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+	jobs := make([]*buildJob, len(plugins))
+	for i, sp := range plugins {
+		log.Println("Discovered ", sp.Name)
+		jobs[i] = p.startBuild(SourceEvent{Action: SourceAdd, Plugin: sp})
+	}
+	exceeded := ""
+	for _, job := range jobs {
+		<-job.done
+		if _, _, exceededByJob, _ := p.activate(job); exceededByJob {
+			exceeded = job.event.Plugin.Name
+		}
+		job.cancel()
 	}
-	pluginLib, err := plugin.Open(p.tempDir + "/" + baseName + "." + version + ".so")
-	if err != nil {
-		return nil, err
+
+	p.emit(Event{
+		Kind:    EventScan,
+		Plugins: p.plugins.snapshot(),
+	})
+	if exceeded != "" {
+		p.recommendRestart(exceeded)
 	}
-	log.Println("Loaded ", baseName+"."+version+".so")
-	return pluginLib, nil
 }
 
-func (p *Pluginator) genVersionedName(baseName string) (string, error) {
+func (p *Pluginator) notifyError(name string, active *PluginContent, err error) {
 
-	files, err := ioutil.ReadDir(p.tempDir)
-	if err != nil {
+	if active != nil {
+		log.Println(err, "- keeping the previous version of", name)
+	} else {
 		log.Println(err)
-		return "", err
 	}
-	latestVersion := baseName
-	for _, file := range files {
-		if !file.IsDir() && strings.HasPrefix(file.Name(), baseName) && strings.HasSuffix(file.Name(), ".so") && file.Name() > latestVersion {
-			latestVersion = file.Name()
+	p.emit(Event{
+		Kind: EventError,
+		Name: name,
+		Old:  active,
+		Err:  err,
+	})
+}
+
+/*
+activate loads the artifact built by job and makes it the active version of its plugin, returning it along with the version
+it replaced. The plugin is initialized, and takes over the state of the version it replaces, before validators are called.
+If the plugin could not be built or loaded, does not satisfy the contract, breaks its API against the policy, fails to
+initialize or is vetoed by a validator, the previous version stays active, the failed attempt is recorded and an error
+event is sent. Either way, it tells whether loading the artifact exceeded the growth policy.
+*/
+func (p *Pluginator) activate(job *buildJob) (*PluginContent, *PluginContent, bool, error) {
+
+	name := job.event.Plugin.Name
+	err := job.err
+	if err == nil && p.apiPolicy == RejectBreakingChanges {
+		err = p.checkAPI(name, job)
+	}
+	var pluginLib Symbols
+	exceeded := false
+	if err == nil {
+		pluginLib, err = p.loader.Load(name, job.artifact)
+	}
+	if err == nil {
+		exceeded = p.recordLoad(name, job.artifact, pluginLib)
+	}
+	if err == nil && p.contract != nil {
+		err = p.contract.check(job.event.Plugin, pluginLib)
+	}
+	var pc *PluginContent
+	if err == nil {
+		pc = &PluginContent{
+			Lib:   pluginLib,
+			Code:  job.event.Plugin.Code,
+			Files: job.event.Plugin.Files,
+			API:   job.api,
 		}
-	}
-	if latestVersion != baseName {
-		fSplit := strings.Split(latestVersion, ".")
-		ver := fSplit[1]
-		verI, err := strconv.Atoi(ver)
-		if err != nil {
-			return "", err
+		active := p.plugins.active(name)
+		err = p.initialize(name, pc, active)
+		if err == nil {
+			if err = p.validate(name, job.event.Plugin.Origin, pc, active); err != nil {
+				p.discard(name, pc)
+			}
 		}
-		verI++
-		return fmt.Sprintf("%09d", verI), nil
 	}
-	return fmt.Sprintf("%09d", 0), nil
-}
-
-func (p *Pluginator) watchConsul() (*consulWatcher, error) {
-
-	cw, err := newConsulWatcher(p.consulHost, p.consulPort, p.consulKeyPrefix)
 	if err != nil {
-		return nil, err
+		active := p.plugins.fail(name, job.event.Plugin, err)
+		p.notifyError(name, active, err)
+		return nil, nil, exceeded, err
 	}
-	go func() {
-		for {
-			select {
-			case event := <-p.consulWatcher.Events:
-				switch event.Action {
-				case consulAddAction:
-					if !strings.HasSuffix(event.Key, ".go") {
-						log.Println("Bad plugin name must end in .go: ", event.Key)
-						break
-					}
-					p.materializeKV(event.Key, event.Value)
-				case consulUpdateAction:
-					p.materializeKV(event.Key, event.Value)
-				case consulRemoveAction:
-					p.unMaterializeK(event.Key)
-				}
-			}
-		}
-	}()
-	return cw, nil
-}
 
-func (p *Pluginator) materializeKV(key, value string) {
-	key = strings.TrimPrefix(key, p.consulKeyPrefix+".")
-	if err := ioutil.WriteFile(p.pluginDir+"/"+key, []byte(value), os.ModePerm); err != nil {
-		log.Println(err)
-	}
+	previous := p.plugins.activate(name, pc)
+	return pc, previous, exceeded, nil
 }
 
-func (p *Pluginator) unMaterializeK(key string) {
-	key = strings.TrimPrefix(key, p.consulKeyPrefix+".")
-	if err := os.Remove(p.pluginDir + "/" + key); err != nil {
-		log.Println(err)
+/*
+checkAPI returns a *BreakingChangeError if job changes the exported API of the active version of plugin name, or if
+either API is not known, and so cannot be told not to break.
+*/
+func (p *Pluginator) checkAPI(name string, job *buildJob) error {
+
+	active := p.plugins.active(name)
+	if active == nil {
+		return nil
+	}
+	diff := diffAPI(active.API, job.api)
+	if diff != nil && !diff.Breaking() {
+		return nil
+	}
+	return &BreakingChangeError{
+		Plugin: name,
+		Origin: job.event.Plugin.Origin,
+		Diff:   diff,
 	}
 }
@@ -64,6 +64,30 @@ func updateTestFile(fileName, content string) error {
 	return ioutil.WriteFile(fileName, []byte(content), 700)
 }
 
+// replaceTestFile atomically replaces fileName, so that watchers never see it partially written
+func replaceTestFile(fileName, content string) error {
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return errors.New(fileName + " does not exist")
+	}
+	return createTestFile(fileName, content)
+}
+
+// createTestFile atomically writes fileName. The content is written outside of fileName's directory, which may be
+// watched too.
+func createTestFile(fileName, content string) error {
+	tmpFile, err := ioutil.TempFile("", "createtestfile")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.WriteString(content)
+	tmpFile.Close()
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return os.Rename(tmpFile.Name(), fileName)
+}
+
 func readTestFile(fileName string) (string, error) {
 	if _, err := os.Stat(fileName); os.IsNotExist(err) {
 		return "", errors.New(fileName + " does not exist")
@@ -81,6 +105,8 @@ type EventSubscriber struct {
 	RemoveDone     chan bool
 	UpdateDone     chan bool
 	AddDone        chan bool
+	ErrorDone      chan bool
+	ReplaceDone    chan bool
 	AddedName      string
 	AddedLib       *PluginContent
 	RemovedName    string
@@ -88,6 +114,12 @@ type EventSubscriber struct {
 	ScannedPlugins map[string]*PluginContent
 	UpdatedName    string
 	UpdatedLib     *PluginContent
+	ReplacedName   string
+	ReplacedOld    *PluginContent
+	ReplacedNew    *PluginContent
+	ErrorName      string
+	ErrorActiveLib *PluginContent
+	Err            error
 }
 
 func (e *EventSubscriber) ScanSubscriber(pluginNamesAndLibs map[string]*PluginContent) {
@@ -114,6 +146,15 @@ func (e *EventSubscriber) UpdateSubscriber(pluginName string, pluginLib *PluginC
 	}()
 }
 
+func (e *EventSubscriber) ReplaceSubscriber(pluginName string, oldLib, newLib *PluginContent) {
+	e.ReplacedName = pluginName
+	e.ReplacedOld = oldLib
+	e.ReplacedNew = newLib
+	go func() {
+		e.ReplaceDone <- true
+	}()
+}
+
 func (e *EventSubscriber) AddSubscriber(pluginName string, pluginLib *PluginContent) {
 	e.AddedName = pluginName
 	e.AddedLib = pluginLib
@@ -121,3 +162,12 @@ func (e *EventSubscriber) AddSubscriber(pluginName string, pluginLib *PluginCont
 		e.AddDone <- true
 	}()
 }
+
+func (e *EventSubscriber) ErrorSubscriber(pluginName string, activeLib *PluginContent, err error) {
+	e.ErrorName = pluginName
+	e.ErrorActiveLib = activeLib
+	e.Err = err
+	go func() {
+		e.ErrorDone <- true
+	}()
+}
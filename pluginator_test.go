@@ -3,10 +3,15 @@
 package pluginator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPluginator(t *testing.T) {
@@ -37,10 +42,11 @@ func TestPluginator(t *testing.T) {
 	}
 
 	es := EventSubscriber{
-		ScanDone:   make(chan bool),
-		RemoveDone: make(chan bool),
-		UpdateDone: make(chan bool),
-		AddDone:    make(chan bool),
+		ScanDone:    make(chan bool),
+		RemoveDone:  make(chan bool),
+		UpdateDone:  make(chan bool),
+		AddDone:     make(chan bool),
+		ReplaceDone: make(chan bool),
 	}
 
 	pluginator.SubscribeScan(es.ScanSubscriber)
@@ -90,6 +96,17 @@ func TestPluginator(t *testing.T) {
 		if p1.Code != p1Code {
 			t.Fatal("Should be able to read a plugins code")
 		}
+		if names := pluginator.List(); len(names) != 2 || names[0] != "plugin1" || names[1] != "plugin2" {
+			t.Fatal("Should be able to list plugins")
+		}
+		if p, exists := pluginator.Get("plugin1"); !exists || p != p1 {
+			t.Fatal("Should be able to get a plugin")
+		}
+	}
+	snapshot := pluginator.Snapshot()
+	snapshot["plugin2"].Code = ""
+	if p2, _ := pluginator.Get("plugin2"); p2.Code == "" || snapshot["plugin2"].Lib != p2.Lib {
+		t.Fatal("Should be able to change a snapshot without affecting the registry")
 	}
 
 	pluginator.SubscribeRemove(es.RemoveSubscriber)
@@ -103,6 +120,12 @@ func TestPluginator(t *testing.T) {
 		if es.RemovedName != "plugin1" {
 			t.Fatal("Should be able to remove a plugin")
 		}
+		if _, exists := pluginator.Get("plugin1"); exists {
+			t.Fatal("Should be able to remove a plugin from the registry")
+		}
+		if _, exists := snapshot["plugin1"]; !exists {
+			t.Fatal("Should be able to keep a snapshot unchanged")
+		}
 		addPtr, err := es.RemovedLib.Lib.Lookup("Add")
 		if err != nil {
 			t.Fatal("Should be able to lookup a symbol")
@@ -117,7 +140,12 @@ func TestPluginator(t *testing.T) {
 		}
 	}
 	pluginator.SubscribeUpdate(es.UpdateSubscriber)
+	pluginator.SubscribeReplace(es.ReplaceSubscriber)
 
+	p2Code, err := readTestFile(tempPluginDir + "/plugin2.go")
+	if err != nil {
+		t.Fatal(err)
+	}
 	content, err := readTestFile(testDataDir + "/plugin1.go")
 	if err != nil {
 		t.Fatal(err)
@@ -147,6 +175,15 @@ func TestPluginator(t *testing.T) {
 			t.Fatal("Should be able to invoke loaded function")
 		}
 	}
+	select {
+	case _ = <-es.ReplaceDone:
+		if es.ReplacedName != "plugin2" || es.ReplacedOld.Code != p2Code || es.ReplacedNew != es.UpdatedLib {
+			t.Fatal("Should be able to receive the old and new versions of an updated plugin")
+		}
+		if _, err := es.ReplacedOld.Lib.Lookup("Sub"); err != nil {
+			t.Fatal("Should be able to lookup a symbol in the old version")
+		}
+	}
 	pluginator.SubscribeAdd(es.AddSubscriber)
 	err = copyTestFile(tempPluginDir+"/plugin3.go", testDataDir+"/plugin3.go")
 	if err != nil {
@@ -171,5 +208,519 @@ func TestPluginator(t *testing.T) {
 			t.Fatal("Should be able to invoke loaded function")
 		}
 	}
-	pluginator.Terminate()
+	pluginator.Terminate(context.Background())
+}
+
+func TestBuildCache(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+	cacheDir, err := ioutil.TempDir("", "testcachedir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	err = copyTestFile(tempPluginDir+"/plugin1.go", testDataDir+"/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := func() *EventSubscriber {
+		pluginator, err := NewPluginatorF(tempPluginDir, WithCacheDir(cacheDir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		es := &EventSubscriber{
+			ScanDone: make(chan bool),
+		}
+		pluginator.SubscribeScan(es.ScanSubscriber)
+		err = pluginator.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-es.ScanDone
+		pluginator.Terminate(context.Background())
+		if _, exists := es.ScannedPlugins["plugin1"]; !exists {
+			t.Fatal("Should be able to load a plugin")
+		}
+		return es
+	}
+
+	start()
+	libs, err := filepath.Glob(cacheDir + "/plugin1-*.so")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(libs) != 1 {
+		t.Fatal("Should be able to cache a compiled plugin")
+	}
+	built, err := os.Stat(libs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start()
+	cached, err := os.Stat(libs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached.ModTime().Equal(built.ModTime()) {
+		t.Fatal("Should be able to load an unchanged plugin from the cache")
+	}
+}
+
+func TestCompileError(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	broken := "package main\n\nfunc Add(x, y int) int {\n\treturn x + z\n}\n"
+	err = ioutil.WriteFile(tempPluginDir+"/broken.go", []byte(broken), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := EventSubscriber{
+		ErrorDone: make(chan bool),
+	}
+	pluginator.SubscribeError(es.ErrorSubscriber)
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	<-es.ErrorDone
+	if es.ErrorName != "broken" {
+		t.Fatal("Should be able to report a compile error")
+	}
+	ce, ok := es.Err.(*CompileError)
+	if !ok {
+		t.Fatal("Should be able to report a compile error as a CompileError")
+	}
+	if len(ce.Diagnostics) != 1 {
+		t.Fatal("Should be able to parse compiler diagnostics")
+	}
+	d := ce.Diagnostics[0]
+	if d.File != tempPluginDir+"/broken.go" || d.Line != 4 || d.Column != 13 || !strings.Contains(d.Message, "z") {
+		t.Fatal("Should be able to map a diagnostic to the plugin source, got ", d)
+	}
+	if ce.Error() != "cannot compile "+tempPluginDir+"/broken.go:\n"+d.String() {
+		t.Fatal("Should be able to tell what plugin does not compile, got ", ce.Error())
+	}
+}
+
+func TestLastKnownGood(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = copyTestFile(tempPluginDir+"/plugin1.go", testDataDir+"/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p1Code, err := readTestFile(testDataDir + "/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p3Code, err := readTestFile(testDataDir + "/plugin3.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es := EventSubscriber{
+		ScanDone:   make(chan bool),
+		UpdateDone: make(chan bool),
+		ErrorDone:  make(chan bool),
+	}
+	pluginator.SubscribeScan(es.ScanSubscriber)
+	pluginator.SubscribeUpdate(es.UpdateSubscriber)
+	pluginator.SubscribeError(es.ErrorSubscriber)
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+	<-es.ScanDone
+
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", "package main\n\nfunc Add(x, y int) int {\n\treturn x + z\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-es.ErrorDone
+	if es.ErrorName != "plugin1" || es.ErrorActiveLib == nil || es.ErrorActiveLib.Code != p1Code {
+		t.Fatal("Should be able to keep the last known good version of a plugin")
+	}
+	if _, err := es.ErrorActiveLib.Lib.Lookup("Add"); err != nil {
+		t.Fatal("Should be able to keep the last known good version in service")
+	}
+	if pluginator.LastFailure("plugin1") == nil {
+		t.Fatal("Should be able to record a failed update")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", p3Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-es.UpdateDone
+	if es.UpdatedName != "plugin1" || es.UpdatedLib.Code != p3Code {
+		t.Fatal("Should be able to update a plugin after a failed update")
+	}
+	if pluginator.LastFailure("plugin1") != nil {
+		t.Fatal("Should be able to clear a failed update")
+	}
+}
+
+func TestTerminate(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = copyTestFile(tempPluginDir+"/plugin1.go", testDataDir+"/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir, WithTempDirCleanup())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, events); event.Kind != EventScan {
+		t.Fatal("Should be able to receive a scan event")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = pluginator.Terminate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-events; ok {
+		t.Fatal("Should be able to close event channels on terminate")
+	}
+	if _, err := os.Stat(pluginator.tempDir); !os.IsNotExist(err) {
+		t.Fatal("Should be able to remove the temp dir on terminate")
+	}
+	err = pluginator.Terminate(ctx)
+	if err != nil {
+		t.Fatal("Should be able to terminate twice")
+	}
+	if err = pluginator.Start(); err == nil {
+		t.Fatal("Should not be able to start a terminated Pluginator")
+	}
+
+	source := newFakeSource()
+	builder := blockingBuilder{cancelled: make(chan string, 1)}
+	pluginator, err = NewPluginator(source, WithQuietPeriod(0), WithBuilder(builder), WithLoader(fakeLoader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.changes <- SourceEvent{Action: SourceAdd, Plugin: SourcePlugin{Name: "changing", Code: "Speed=slow\n"}}
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = pluginator.Terminate(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Should be able to tell why termination was not graceful, got ", err)
+	}
+	if code := <-builder.cancelled; code != "Speed=slow\n" {
+		t.Fatal("Should be able to kill builds on termination")
+	}
+}
+
+func TestPluginPackage(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = os.Mkdir(tempPluginDir+"/calc", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(tempPluginDir+"/calc/calc.go", []byte("package main\n\nfunc Add(x, y int) int {\n\treturn add(x, y)\n}\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(tempPluginDir+"/calc/helpers.go", []byte("package main\n\nfunc add(x, y int) int {\n\treturn x + y\n}\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(tempPluginDir+"/generic", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generic := "package main\n\nfunc sum[T int | float64](xs ...T) T {\n\tvar total T\n\tfor i := range len(xs) {\n\t\ttotal += xs[i]\n\t}\n\treturn total\n}\n\nfunc Add(x, y int) int {\n\treturn sum(x, y)\n}\n"
+	err = ioutil.WriteFile(tempPluginDir+"/generic/generic.go", []byte(generic), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	invokeAdd := func(pc *PluginContent) int {
+		addPtr, err := pc.Lib.Lookup("Add")
+		if err != nil {
+			t.Fatal("Should be able to lookup a symbol")
+		}
+		add, ok := addPtr.(func(int, int) int)
+		if !ok {
+			t.Fatal("Should be able to convert to function type")
+		}
+		return add(1, 2)
+	}
+
+	event := nextEvent(t, events)
+	calc, exists := event.Plugins["calc"]
+	if event.Kind != EventScan || !exists {
+		t.Fatal("Should be able to load a plugin package")
+	}
+	if len(calc.Files) != 2 || invokeAdd(calc) != 3 {
+		t.Fatal("Should be able to compile all the files of a plugin package")
+	}
+	if event.Plugins["generic"] == nil || invokeAdd(event.Plugins["generic"]) != 3 {
+		t.Fatal("Should be able to compile a plugin package at the language version of the toolchain")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/calc/helpers.go", "package main\n\nfunc add(x, y int) int {\n\treturn x - y\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || event.Name != "calc" || invokeAdd(event.New) != -1 {
+		t.Fatal("Should be able to rebuild a plugin package when one of its files changes")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/calc/helpers.go", "package main\n\nfunc add(x, y int) int {\n\treturn x - z\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	ce, ok := event.Err.(*CompileError)
+	if event.Kind != EventError || !ok || len(ce.Diagnostics) != 1 {
+		t.Fatal("Should be able to report a compile error in a plugin package")
+	}
+	if d := ce.Diagnostics[0]; d.File != tempPluginDir+"/calc/helpers.go" || d.Line != 4 {
+		t.Fatal("Should be able to map a diagnostic to a file of a plugin package, got ", d)
+	}
+
+	err = os.RemoveAll(tempPluginDir + "/calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// files are removed one by one before the directory, which may cause intermediate events
+	for event = nextEvent(t, events); event.Kind != EventRemove; event = nextEvent(t, events) {
+	}
+	if event.Name != "calc" {
+		t.Fatal("Should be able to remove a plugin package")
+	}
+}
+
+func TestPluginModule(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+	tempVendorDir, err := ioutil.TempDir("", "testvendordir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempVendorDir)
+
+	testFiles := map[string]string{
+		tempVendorDir + "/modules.txt":            "# example.com/lib v1.0.0\n## explicit; go 1.16\nexample.com/lib\n",
+		tempVendorDir + "/example.com/lib/lib.go": "package lib\n\nfunc Add(x, y int) int {\n\treturn x + y\n}\n",
+		tempPluginDir + "/calc/go.mod":            "module example.com/calc\n\ngo 1.16\n\nrequire example.com/lib v1.0.0\n",
+		tempPluginDir + "/calc/calc.go":           "package main\n\nimport \"example.com/lib\"\n\nfunc Add(x, y int) int {\n\treturn lib.Add(x, y)\n}\n",
+		tempPluginDir + "/geo/go.mod":             "module example.com/geo\n\ngo 1.16\n",
+		tempPluginDir + "/geo/geo.go":             "package main\n\nimport \"example.com/geo/shapes\"\n\nvar Unit = shapes.Square{Side: 1}\n\nfunc Area(side int) int {\n\treturn shapes.Square{Side: side}.Area()\n}\n",
+		tempPluginDir + "/geo/shapes/shapes.go":   "package shapes\n\ntype Square struct {\n\tSide int\n}\n\nfunc (s Square) Area() int {\n\treturn s.Side * s.Side\n}\n",
+	}
+	for fileName, content := range testFiles {
+		err = os.MkdirAll(filepath.Dir(fileName), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(fileName, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir, WithVendorDir(tempVendorDir), WithGoProxy("off"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	invokeAdd := func(pc *PluginContent) int {
+		addPtr, err := pc.Lib.Lookup("Add")
+		if err != nil {
+			t.Fatal("Should be able to lookup a symbol")
+		}
+		add, ok := addPtr.(func(int, int) int)
+		if !ok {
+			t.Fatal("Should be able to convert to function type")
+		}
+		return add(1, 2)
+	}
+
+	invokeArea := func(pc *PluginContent) int {
+		areaPtr, err := pc.Lib.Lookup("Area")
+		if err != nil {
+			t.Fatal("Should be able to lookup a symbol")
+		}
+		area, ok := areaPtr.(func(int) int)
+		if !ok {
+			t.Fatal("Should be able to convert to function type")
+		}
+		return area(3)
+	}
+
+	event := nextEvent(t, events)
+	calc, exists := event.Plugins["calc"]
+	if event.Kind != EventScan || !exists || invokeAdd(calc) != 3 {
+		t.Fatal("Should be able to build a plugin module against the vendor dir")
+	}
+	geo, exists := event.Plugins["geo"]
+	if !exists || invokeArea(geo) != 9 {
+		t.Fatal("Should be able to build a plugin module importing its own packages")
+	}
+	if geo.API == nil || len(geo.API.Vars) != 1 || geo.API.Vars[0].Type != "example.com/geo/shapes.Square" {
+		t.Fatal("Should be able to qualify the types of a plugin module by its own path, got ", geo.API)
+	}
+
+	// the module path is the same across versions, so each version must be given its own
+	err = replaceTestFile(tempPluginDir+"/calc/calc.go", "package main\n\nimport \"example.com/lib\"\n\nfunc Add(x, y int) int {\n\treturn lib.Add(x, y) * 2\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || event.Name != "calc" || invokeAdd(event.New) != 6 {
+		t.Fatal("Should be able to load a new version of a plugin module")
+	}
+
+	// so are the packages of a plugin module
+	err = replaceTestFile(tempPluginDir+"/geo/shapes/shapes.go", "package shapes\n\ntype Square struct {\n\tSide int\n}\n\nfunc (s Square) Area() int {\n\treturn s.Side * s.Side * 2\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || event.Name != "geo" || invokeArea(event.New) != 18 {
+		t.Fatal("Should be able to load a new version of a package of a plugin module, got ", event.Kind, event.Err)
+	}
+	if event.Diff != nil && event.Diff.Breaking() {
+		t.Fatal("Should not be able to see a breaking change in a plugin module whose API is unchanged")
+	}
+}
+
+func TestHostModules(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	// the test binary links github.com/google/uuid, at a newer version than the plugin requires
+	err = os.Mkdir(tempPluginDir+"/ids", 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(tempPluginDir+"/ids/go.mod", []byte("module example.com/ids\n\ngo 1.16\n\nrequire github.com/google/uuid v1.1.0\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(tempPluginDir+"/ids/ids.go", []byte("package main\n\nimport \"github.com/google/uuid\"\n\nfunc NewID() string {\n\treturn uuid.New().String()\n}\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, linked := pluginator.hostModules["github.com/google/uuid"]; !linked {
+		t.Skip("Test binary has no module information")
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	event := nextEvent(t, events)
+	ids, exists := event.Plugins["ids"]
+	if event.Kind != EventScan || !exists {
+		t.Fatal("Should be able to load a plugin sharing a module with the host")
+	}
+	newIDPtr, err := ids.Lib.Lookup("NewID")
+	if err != nil {
+		t.Fatal("Should be able to lookup a symbol")
+	}
+	newID, ok := newIDPtr.(func() string)
+	if !ok || len(newID()) != 36 {
+		t.Fatal("Should be able to call a plugin sharing a module with the host")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/ids/go.mod", "module example.com/ids\n\ngo 1.16\n\nrequire github.com/google/uuid v1.99.0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	me, ok := event.Err.(*ModuleMismatchError)
+	if event.Kind != EventError || !ok || len(me.Mismatches) != 1 {
+		t.Fatal("Should be able to report a plugin requiring a newer module than the host's")
+	}
+	if m := me.Mismatches[0]; m.Module != "github.com/google/uuid" || m.PluginVersion != "v1.99.0" {
+		t.Fatal("Should be able to tell which module mismatches, got ", m)
+	}
 }
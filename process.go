@@ -0,0 +1,511 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"plugin"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Runtime is how plugins are run
+type Runtime int
+
+// Runtimes
+const (
+	// InProcessRuntime loads plugins into the host with plugin.Open. It is the default.
+	InProcessRuntime Runtime = iota
+	/*
+		ProcessRuntime builds each plugin as an executable, run in its own process and called over a unix socket: the Lib
+		of its PluginContent is a *Process. A process that exits is restarted; a version that is replaced or removed has its
+		process stopped, so that it is really unloaded, and a plugin that crashes does not take the host down. The process
+		calls the plugin's Init, with nil as the host is not shared, each time it starts, and Shutdown when it is stopped.
+	*/
+	ProcessRuntime
+)
+
+// DefaultProcessTimeout is how long processes of plugins have to start and stop, unless WithProcessTimeout says otherwise
+const DefaultProcessTimeout = 10 * time.Second
+
+const (
+	// shimFile is the file added to plugins built as executables, serving their functions
+	shimFile = "zz_pluginator_shim.go"
+	// shimSocketEnv is the environment variable telling the shim where to connect
+	shimSocketEnv = "PLUGINATOR_SOCKET"
+	// restartDelay is how long a process that exited waits before being restarted
+	restartDelay = time.Second
+)
+
+// ProcessError is sent to error subscribers when the process of a plugin exits, or cannot be restarted
+type ProcessError struct {
+	Plugin string
+	Err    error
+}
+
+func (e *ProcessError) Error() string {
+	return "process of " + e.Plugin + " failed: " + e.Err.Error()
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+/*
+Process is a version of a plugin running in its own process. Its exported functions are called with Call; arguments and
+results travel with encoding/gob, so they must be of types gob knows as interface values, such as basic types and slices
+of them, or types registered with gob.Register in both the host and the plugin.
+*/
+type Process struct {
+	name       string
+	executable string
+	// timeout is how long the process has to connect when started, and to exit when stopped
+	timeout   time.Duration
+	onFailure func(error)
+	socketDir string
+	// calls serializes calls; mutex guards the connection, which is closed without waiting for calls in flight
+	calls   sync.Mutex
+	mutex   sync.Mutex
+	conn    net.Conn
+	encoder *gob.Encoder
+	decoder *gob.Decoder
+	pid     int
+	// funcs are the functions the plugin exports
+	funcs  map[string]bool
+	closed chan struct{}
+	exited chan struct{}
+	// closeErr is set when the process had to be killed, before exited is closed
+	closeErr  error
+	closeOnce sync.Once
+}
+
+// processRequest and processResponse are the messages exchanged with the shim
+type processRequest struct {
+	Func string
+	Args []interface{}
+}
+
+type processResponse struct {
+	Results []interface{}
+	Err     string
+}
+
+/*
+Call calls function, exported by the plugin, with args, and returns its results. An error result is returned as the
+error, the other results in order. Calls are serialized, and fail while the process is being restarted.
+*/
+func (pr *Process) Call(function string, args ...interface{}) ([]interface{}, error) {
+
+	pr.calls.Lock()
+	defer pr.calls.Unlock()
+	pr.mutex.Lock()
+	conn, encoder, decoder := pr.conn, pr.encoder, pr.decoder
+	pr.mutex.Unlock()
+	if conn == nil {
+		return nil, errors.New(pr.name + " is not running")
+	}
+	err := encoder.Encode(processRequest{Func: function, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	var response processResponse
+	if err = decoder.Decode(&response); err != nil {
+		return nil, err
+	}
+	if response.Err != "" {
+		return response.Results, errors.New(response.Err)
+	}
+	return response.Results, nil
+}
+
+/*
+Lookup returns a function calling the function symName exported by the plugin, of type
+func(args ...interface{}) ([]interface{}, error), which works as Call. Variables cannot be looked up.
+*/
+func (pr *Process) Lookup(symName string) (plugin.Symbol, error) {
+
+	if !pr.funcs[symName] {
+		return nil, errors.New("plugin " + pr.name + " does not export function " + symName)
+	}
+	return func(args ...interface{}) ([]interface{}, error) {
+		return pr.Call(symName, args...)
+	}, nil
+}
+
+// OutOfProcess tells that the plugin runs outside the host, making Process RemoteSymbols
+func (pr *Process) OutOfProcess() bool {
+	return true
+}
+
+// Pid returns the process id of the plugin, 0 while it is being restarted
+func (pr *Process) Pid() int {
+
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	return pr.pid
+}
+
+/*
+Close stops the process: the shim calls the plugin's Shutdown, if any, and exits. A process that does not exit in time is
+killed. Close can be called more than once.
+*/
+func (pr *Process) Close() error {
+
+	pr.closeOnce.Do(func() {
+		close(pr.closed)
+	})
+	<-pr.exited
+	return pr.closeErr
+}
+
+// startProcess runs the executable of plugin name, reporting the failures of its process to error subscribers
+func (p *Pluginator) startProcess(name, executable string) (*Process, error) {
+
+	socketDir, err := ioutil.TempDir("", "pluginator")
+	if err != nil {
+		return nil, err
+	}
+	pr := &Process{
+		name:       name,
+		executable: executable,
+		timeout:    p.processTimeout,
+		socketDir:  socketDir,
+		closed:     make(chan struct{}),
+		exited:     make(chan struct{}),
+	}
+	pr.onFailure = func(err error) {
+		if p.ctx.Err() == nil {
+			p.notifyError(name, p.plugins.active(name), &ProcessError{Plugin: name, Err: err})
+		}
+	}
+	r, err := pr.start()
+	if err != nil {
+		os.RemoveAll(socketDir)
+		return nil, &ProcessError{Plugin: name, Err: err}
+	}
+	log.Println("Started ", strings.TrimPrefix(executable, p.cacheDir+"/"))
+	go pr.supervise(r)
+	// the shim lists the functions it serves when asked for the unnamed one
+	funcs, err := pr.Call("")
+	if err != nil {
+		pr.Close()
+		return nil, &ProcessError{Plugin: name, Err: err}
+	}
+	pr.funcs = make(map[string]bool, len(funcs))
+	for _, f := range funcs {
+		pr.funcs[f.(string)] = true
+	}
+	return pr, nil
+}
+
+// run is a run of the executable of a Process
+type run struct {
+	command *exec.Cmd
+	// exited is closed when the process exits, err being how
+	exited chan struct{}
+	err    error
+}
+
+// start runs the executable and waits for the shim to connect, or for the process to exit first
+func (pr *Process) start() (*run, error) {
+
+	socket := pr.socketDir + "/socket"
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	command := exec.Command(pr.executable)
+	command.Env = append(os.Environ(), shimSocketEnv+"="+socket)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	if err = command.Start(); err != nil {
+		return nil, err
+	}
+	r := &run{command: command, exited: make(chan struct{})}
+	go func() {
+		r.err = command.Wait()
+		close(r.exited)
+	}()
+	connected := make(chan struct{})
+	defer close(connected)
+	go func() {
+		select {
+		case <-r.exited:
+			// stops waiting for a connection that will not come
+			listener.Close()
+		case <-connected:
+		}
+	}()
+	listener.(*net.UnixListener).SetDeadline(time.Now().Add(pr.timeout))
+	conn, err := listener.Accept()
+	if err != nil {
+		select {
+		case <-r.exited:
+			if r.err == nil {
+				return nil, errors.New("exited before connecting")
+			}
+			return nil, errors.New("exited before connecting: " + r.err.Error())
+		default:
+		}
+		command.Process.Kill()
+		<-r.exited
+		return nil, errors.New("did not connect: " + err.Error())
+	}
+	pr.mutex.Lock()
+	pr.conn = conn
+	pr.encoder = gob.NewEncoder(conn)
+	pr.decoder = gob.NewDecoder(conn)
+	pr.pid = command.Process.Pid
+	pr.mutex.Unlock()
+	return r, nil
+}
+
+// disconnect closes the connection to the process, making calls in flight fail
+func (pr *Process) disconnect() {
+
+	pr.mutex.Lock()
+	conn := pr.conn
+	pr.conn = nil
+	pr.pid = 0
+	pr.mutex.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+/*
+supervise waits for the process to exit, restarting it, until the Process is closed. Closing the connection tells the
+shim to shut the plugin down.
+*/
+func (pr *Process) supervise(r *run) {
+
+	defer close(pr.exited)
+	defer os.RemoveAll(pr.socketDir)
+	for {
+		select {
+		case <-r.exited:
+			pr.disconnect()
+			err := r.err
+			if err == nil {
+				err = errors.New("exited")
+			}
+			pr.onFailure(err)
+		case <-pr.closed:
+			pr.disconnect()
+			select {
+			case <-r.exited:
+			case <-time.After(pr.timeout):
+				r.command.Process.Kill()
+				<-r.exited
+				pr.closeErr = &ProcessError{Plugin: pr.name, Err: errors.New("killed, as it did not exit in time")}
+			}
+			return
+		}
+		for {
+			select {
+			case <-pr.closed:
+				return
+			case <-time.After(restartDelay):
+			}
+			var err error
+			r, err = pr.start()
+			if err == nil {
+				log.Println("Restarted ", pr.name)
+				break
+			}
+			pr.onFailure(err)
+		}
+	}
+}
+
+// writeExecutable writes into dir the package building plugin sp as an executable, with the shim serving its functions
+func (p *Pluginator) writeExecutable(ctx context.Context, dir string, sp SourcePlugin, versionedName string) error {
+
+	files := sp.Files
+	if files == nil {
+		files = map[string]string{sp.Name + ".go": sp.Code}
+	}
+	files = copyFiles(files)
+	files[shimFile] = shimSource(files)
+	return p.writePackage(ctx, dir, SourcePlugin{Name: sp.Name, Origin: sp.Origin, Files: files}, "pluginator/"+versionedName)
+}
+
+/*
+shimSource returns the shim of a plugin package: an init function that, when the executable is started by pluginator,
+calls the plugin's Init, connects to the host and calls the functions exported by the package on request, until the host
+disconnects. It runs after the plugin's own init functions, as its file comes last, and never returns to main. Files that
+do not parse are left to the compiler to report.
+*/
+func shimSource(files map[string]string) string {
+
+	fset := token.NewFileSet()
+	var funcs []string
+	hasMain := false
+	for path, content := range files {
+		if strings.Contains(path, "/") || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, content, 0)
+		if err != nil {
+			continue
+		}
+		for _, decl := range file.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Recv != nil {
+				continue
+			}
+			switch {
+			case funcDecl.Name.Name == "main":
+				hasMain = true
+			case funcDecl.Name.IsExported() && funcDecl.Type.TypeParams == nil:
+				funcs = append(funcs, funcDecl.Name.Name)
+			}
+		}
+	}
+	sort.Strings(funcs)
+	var table strings.Builder
+	for _, name := range funcs {
+		table.WriteString("\t\"" + name + "\": " + name + ",\n")
+	}
+	shim := strings.Replace(shimTemplate, "FUNCS\n", table.String(), 1)
+	if !hasMain {
+		shim += "\nfunc main() {}\n"
+	}
+	return shim
+}
+
+// shimTemplate is the source of the shim. Its package-level names are prefixed, not to clash with the plugin's.
+const shimTemplate = `// Code generated by pluginator. DO NOT EDIT.
+
+package main
+
+import (
+	pluginatorGob "encoding/gob"
+	pluginatorFmt "fmt"
+	pluginatorNet "net"
+	pluginatorOS "os"
+	pluginatorReflect "reflect"
+)
+
+var pluginatorFuncs = map[string]interface{}{
+FUNCS
+}
+
+type pluginatorRequest struct {
+	Func string
+	Args []interface{}
+}
+
+type pluginatorResponse struct {
+	Results []interface{}
+	Err     string
+}
+
+func init() {
+
+	socket := pluginatorOS.Getenv("` + shimSocketEnv + `")
+	if socket == "" {
+		return
+	}
+	if initHook, ok := pluginatorFuncs["Init"].(func(interface{}) error); ok {
+		if err := initHook(nil); err != nil {
+			pluginatorFmt.Fprintln(pluginatorOS.Stderr, "Init failed:", err)
+			pluginatorOS.Exit(1)
+		}
+	}
+	conn, err := pluginatorNet.Dial("unix", socket)
+	if err != nil {
+		pluginatorFmt.Fprintln(pluginatorOS.Stderr, err)
+		pluginatorOS.Exit(1)
+	}
+	decoder := pluginatorGob.NewDecoder(conn)
+	encoder := pluginatorGob.NewEncoder(conn)
+	for {
+		var request pluginatorRequest
+		if decoder.Decode(&request) != nil {
+			break
+		}
+		if err := encoder.Encode(pluginatorCall(request)); err != nil {
+			encoder.Encode(pluginatorResponse{Err: err.Error()})
+		}
+	}
+	if shutdown, ok := pluginatorFuncs["Shutdown"].(func() error); ok {
+		if err := shutdown(); err != nil {
+			pluginatorFmt.Fprintln(pluginatorOS.Stderr, "Shutdown failed:", err)
+		}
+	}
+	pluginatorOS.Exit(0)
+}
+
+func pluginatorCall(request pluginatorRequest) (response pluginatorResponse) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			response = pluginatorResponse{Err: pluginatorFmt.Sprint("panic: ", r)}
+		}
+	}()
+	if request.Func == "" {
+		for name := range pluginatorFuncs {
+			response.Results = append(response.Results, name)
+		}
+		return response
+	}
+	f, exists := pluginatorFuncs[request.Func]
+	if !exists {
+		return pluginatorResponse{Err: "no function " + request.Func}
+	}
+	function := pluginatorReflect.ValueOf(f)
+	signature := function.Type()
+	fixed := signature.NumIn()
+	if signature.IsVariadic() {
+		fixed--
+	}
+	if len(request.Args) < fixed || !signature.IsVariadic() && len(request.Args) > fixed {
+		return pluginatorResponse{Err: pluginatorFmt.Sprintf("%s takes %d arguments, not %d", request.Func, signature.NumIn(), len(request.Args))}
+	}
+	args := make([]pluginatorReflect.Value, len(request.Args))
+	for i, arg := range request.Args {
+		var argType pluginatorReflect.Type
+		if i < fixed {
+			argType = signature.In(i)
+		} else {
+			argType = signature.In(fixed).Elem()
+		}
+		if arg == nil {
+			args[i] = pluginatorReflect.Zero(argType)
+			continue
+		}
+		args[i] = pluginatorReflect.ValueOf(arg)
+		if !args[i].Type().AssignableTo(argType) {
+			return pluginatorResponse{Err: pluginatorFmt.Sprintf("argument %d of %s is %s, not %s", i, request.Func, args[i].Type(), argType)}
+		}
+	}
+	results := function.Call(args)
+	errorType := pluginatorReflect.TypeOf((*error)(nil)).Elem()
+	if n := len(results); n > 0 && signature.Out(n-1) == errorType {
+		if err := results[n-1]; !err.IsNil() {
+			response.Err = err.Interface().(error).Error()
+		}
+		results = results[:n-1]
+	}
+	for _, result := range results {
+		response.Results = append(response.Results, result.Interface())
+	}
+	return response
+}
+`
@@ -0,0 +1,300 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// processPlugin returns the code of a plugin adding offset to its sums, telling whether it was initialized, and recording
+// its shutdown in the marker file
+func processPlugin(offset string) string {
+	return `package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+func Add(x, y int) int {
+	return x + y + ` + offset + `
+}
+
+func Div(x, y int) (int, error) {
+	if y == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return x / y, nil
+}
+
+func Crash() {
+	os.Exit(3)
+}
+
+var initialized bool
+
+func Init(host interface{}) error {
+	initialized = host == nil
+	return nil
+}
+
+func Initialized() bool {
+	return initialized
+}
+
+func Shutdown() error {
+	return ioutil.WriteFile(os.Getenv("PLUGINATOR_TEST_MARKER"), []byte("` + offset + `"), 0600)
+}
+`
+}
+
+func TestProcessRuntime(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+	marker := tempPluginDir + ".marker"
+	defer os.Remove(marker)
+	t.Setenv("PLUGINATOR_TEST_MARKER", marker)
+
+	err = createTestFile(tempPluginDir+"/calc.go", processPlugin("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPluginatorF(tempPluginDir, WithRuntime(ProcessRuntime), WithContract(Contract{}))
+	if err == nil {
+		t.Fatal("Should not be able to check contracts of plugins run in their own process")
+	}
+	pluginator, err := NewPluginatorF(tempPluginDir, WithRuntime(ProcessRuntime))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := nextEvent(t, events)
+	if event.Kind != EventScan || event.Plugins["calc"] == nil {
+		t.Fatal("Should be able to receive a scan event")
+	}
+	calc, ok := event.Plugins["calc"].Lib.(*Process)
+	if !ok {
+		t.Fatal("Should be able to run a plugin in its own process")
+	}
+	results, err := calc.Call("Add", 1, 2)
+	if err != nil || len(results) != 1 || results[0] != 3 {
+		t.Fatal("Should be able to call a plugin's function, got ", results, err)
+	}
+	results, err = calc.Call("Initialized")
+	if err != nil || len(results) != 1 || results[0] != true {
+		t.Fatal("Should be able to initialize a plugin in its own process")
+	}
+	results, err = calc.Call("Div", 1, 0)
+	if err == nil || err.Error() != "division by zero" || len(results) != 1 {
+		t.Fatal("Should be able to receive the error returned by a plugin's function")
+	}
+	_, err = calc.Call("Add", "1", 2)
+	if err == nil {
+		t.Fatal("Should not be able to call a function with arguments of the wrong type")
+	}
+	_, err = calc.Call("Sub", 1, 2)
+	if err == nil {
+		t.Fatal("Should not be able to call a function the plugin does not export")
+	}
+	add, err := calc.Lookup("Add")
+	if err != nil {
+		t.Fatal("Should be able to lookup a function of a plugin")
+	}
+	results, err = add.(func(...interface{}) ([]interface{}, error))(2, 3)
+	if err != nil || results[0] != 5 {
+		t.Fatal("Should be able to call a function looked up")
+	}
+	if _, err = calc.Lookup("Sub"); err == nil {
+		t.Fatal("Should not be able to lookup a function the plugin does not export")
+	}
+
+	pid := calc.Pid()
+	_, err = calc.Call("Crash")
+	if err == nil {
+		t.Fatal("Should be able to see a call fail when the process crashes")
+	}
+	event = nextEvent(t, events)
+	var processErr *ProcessError
+	if event.Kind != EventError || !errors.As(event.Err, &processErr) || event.Old.Lib != calc {
+		t.Fatal("Should be able to report a crashed process")
+	}
+	if !waitFor(func() bool {
+		results, err = calc.Call("Add", 1, 2)
+		return err == nil
+	}) || calc.Pid() == pid {
+		t.Fatal("Should be able to restart a crashed process")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/calc.go", processPlugin("10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventUpdate || event.New.API == nil || len(event.New.API.Funcs) != 6 {
+		t.Fatal("Should be able to read the API of a plugin run in its own process")
+	}
+	results, err = event.New.Lib.(*Process).Call("Add", 1, 2)
+	if err != nil || results[0] != 13 {
+		t.Fatal("Should be able to call the new version of a plugin")
+	}
+	if !waitFor(func() bool {
+		content, _ := ioutil.ReadFile(marker)
+		return string(content) == "0"
+	}) {
+		t.Fatal("Should be able to shut down the process of a replaced version")
+	}
+	if _, err = calc.Call("Add", 1, 2); err == nil {
+		t.Fatal("Should not be able to call a replaced version")
+	}
+
+	err = deleteTestFile(tempPluginDir + "/calc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventRemove || !waitFor(func() bool {
+		content, _ := ioutil.ReadFile(marker)
+		return string(content) == "10"
+	}) {
+		t.Fatal("Should be able to stop the process of a removed plugin")
+	}
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessTimeout(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = createTestFile(tempPluginDir+"/hanging.go", "package main\n\nimport \"time\"\n\nfunc Hang() {\n\ttime.Sleep(time.Hour)\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pluginator, err := NewPluginatorF(tempPluginDir, WithRuntime(ProcessRuntime), WithProcessTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	if event.Kind != EventScan || event.Plugins["hanging"] == nil {
+		t.Fatal("Should be able to receive a scan event")
+	}
+	hanging := event.Plugins["hanging"].Lib.(*Process)
+	hung := make(chan error, 1)
+	go func() {
+		_, err := hanging.Call("Hang")
+		hung <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	err = pluginator.Terminate(context.Background())
+	var processErr *ProcessError
+	if !errors.As(err, &processErr) || time.Since(start) > 5*time.Second {
+		t.Fatal("Should be able to kill a process that does not stop in time, got ", err)
+	}
+	select {
+	case err = <-hung:
+		if err == nil {
+			t.Fatal("Should be able to fail a hanging call when the process is stopped")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Should be able to end a hanging call when the process is stopped")
+	}
+	if _, err = hanging.Call("Hang"); err == nil {
+		t.Fatal("Should not be able to call a stopped process")
+	}
+}
+
+func TestProcessInit(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = createTestFile(tempPluginDir+"/failing.go", "package main\n\nimport \"errors\"\n\nfunc Init(host interface{}) error {\n\treturn errors.New(\"no database\")\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pluginator, err := NewPluginatorF(tempPluginDir, WithRuntime(ProcessRuntime), WithProcessTimeout(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	start := time.Now()
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	var processErr *ProcessError
+	if event.Kind != EventError || !errors.As(event.Err, &processErr) || time.Since(start) > 30*time.Second {
+		t.Fatal("Should be able to fail a process whose Init fails as soon as it exits, got ", event.Kind, event.Err)
+	}
+	event = nextEvent(t, events)
+	if event.Kind != EventScan || event.Plugins["failing"] != nil {
+		t.Fatal("Should not be able to activate a plugin whose Init fails")
+	}
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShimSource(t *testing.T) {
+
+	shim := shimSource(map[string]string{
+		"plugin.go":      "package main\n\nfunc Add(x, y int) int { return x + y }\n\nfunc Map[T any](t T) T { return t }\n\nfunc (t T) M() {}\n\nfunc main() {}\n",
+		"sub/sub.go":     "package sub\n\nfunc Sub() {}\n",
+		"plugin_test.go": "package main\n\nfunc Test() {}\n",
+	})
+	if !containsLine(shim, "\t\"Add\": Add,") || containsLine(shim, "\t\"Map\": Map,") || containsLine(shim, "\t\"M\": M,") ||
+		containsLine(shim, "\t\"Sub\": Sub,") || containsLine(shim, "\t\"Test\": Test,") {
+		t.Fatal("Should be able to serve the exported functions of a plugin, got ", shim)
+	}
+	if containsLine(shim, "func main() {}") {
+		t.Fatal("Should not be able to declare main when the plugin does")
+	}
+	if !containsLine(shimSource(map[string]string{"plugin.go": "package main\n"}), "func main() {}") {
+		t.Fatal("Should be able to declare main when the plugin does not")
+	}
+}
+
+func containsLine(text, line string) bool {
+
+	for _, l := range strings.Split(text, "\n") {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// listenersEnv names the listeners inherited from the process that re-executed this one, comma separated. Their file
+	// descriptors follow stderr, in order.
+	listenersEnv = "PLUGINATOR_LISTENERS"
+	// readyEnv is the file descriptor of the pipe on which the new process tells the old one it is ready
+	readyEnv = "PLUGINATOR_READY_FD"
+	// cacheDirEnv is the temporary build cache handed over to the new process, so that it starts warm
+	cacheDirEnv = "PLUGINATOR_CACHE_DIR"
+	// reexecTimeout is how long the new process has to get ready when the growth policy makes the host re-exec
+	reexecTimeout = time.Minute
+)
+
+// ReexecError is sent to error subscribers when the host cannot be re-executed as the growth policy is exceeded
+type ReexecError struct {
+	Err error
+}
+
+func (e *ReexecError) Error() string {
+	return "cannot re-execute the host: " + e.Err.Error()
+}
+
+func (e *ReexecError) Unwrap() error {
+	return e.Err
+}
+
+// init keeps the listeners and the ready pipe handed over from the processes this one starts, like the go tool or plugin
+// processes, before Listen and Ready take them
+func init() {
+
+	if names := os.Getenv(listenersEnv); names != "" {
+		for i := range strings.Split(names, ",") {
+			syscall.CloseOnExec(3 + i)
+		}
+	}
+	if fd, err := strconv.Atoi(os.Getenv(readyEnv)); err == nil {
+		syscall.CloseOnExec(fd)
+	}
+}
+
+// handover is the state of the process shared with the one re-executing it, or re-executed by it
+var handover = struct {
+	mutex sync.Mutex
+	// inherited are the listeners inherited and not claimed yet, by name
+	inherited map[string]net.Listener
+	parsed    bool
+	// listeners are the listeners to hand over, in the order they were obtained
+	names     []string
+	listeners []net.Listener
+}{}
+
+/*
+Listen returns the listener named name that the process re-executing this one handed over, or, if there is none, a new
+one listening on network and address. Listeners obtained with Listen are handed over in turn when the host re-executes,
+unless they were closed.
+*/
+func Listen(name, network, address string) (net.Listener, error) {
+
+	handover.mutex.Lock()
+	defer handover.mutex.Unlock()
+	if !handover.parsed {
+		handover.parsed = true
+		handover.inherited = inheritListeners()
+	}
+	listener, inherited := handover.inherited[name]
+	if inherited {
+		delete(handover.inherited, name)
+	} else {
+		var err error
+		if listener, err = net.Listen(network, address); err != nil {
+			return nil, err
+		}
+	}
+	handover.names = append(handover.names, name)
+	handover.listeners = append(handover.listeners, listener)
+	return listener, nil
+}
+
+// inheritListeners returns the listeners handed over by the process that re-executed this one, by name
+func inheritListeners() map[string]net.Listener {
+
+	inherited := make(map[string]net.Listener)
+	names := os.Getenv(listenersEnv)
+	os.Unsetenv(listenersEnv)
+	if names == "" {
+		return inherited
+	}
+	for i, name := range strings.Split(names, ",") {
+		file := os.NewFile(uintptr(3+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			log.Println("Cannot inherit listener", name+":", err)
+			continue
+		}
+		inherited[name] = listener
+	}
+	return inherited
+}
+
+/*
+Ready tells the process that re-executed this one, if any, that the host is ready to serve, so that it can drain and exit.
+It is to be called once the listeners obtained with Listen serve, and plugins are loaded.
+*/
+func Ready() error {
+
+	fd := os.Getenv(readyEnv)
+	os.Unsetenv(readyEnv)
+	if fd == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return errors.New("invalid " + readyEnv + ": " + fd)
+	}
+	pipe := os.NewFile(uintptr(n), "ready")
+	defer pipe.Close()
+	_, err = pipe.Write([]byte{1})
+	return err
+}
+
+/*
+Reexec starts a new instance of the host binary, with the same arguments and environment, handing it the listeners
+obtained with Listen and, if there is no cache dir, the temporary build cache, so that it starts warm. It returns once
+the new process called Ready: this one should then stop accepting on its listeners, drain the work in flight, and exit.
+If the new process exits, or ctx is done, before it is ready, it is killed and an error is returned.
+*/
+func (p *Pluginator) Reexec(ctx context.Context) error {
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyRead.Close()
+
+	handover.mutex.Lock()
+	var files []*os.File
+	var names []string
+	var unixListeners []*net.UnixListener
+	for i := 0; i < len(handover.listeners); i++ {
+		listener := handover.listeners[i]
+		filer, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			err = fmt.Errorf("cannot hand over a listener of type %T", listener)
+			break
+		}
+		file, fileErr := filer.File()
+		if errors.Is(fileErr, net.ErrClosed) {
+			// closed by the host, so there is nothing to hand over any more
+			handover.names = append(handover.names[:i], handover.names[i+1:]...)
+			handover.listeners = append(handover.listeners[:i], handover.listeners[i+1:]...)
+			i--
+			continue
+		}
+		if fileErr != nil {
+			err = fileErr
+			break
+		}
+		files = append(files, file)
+		names = append(names, handover.names[i])
+		if unixListener, isUnix := listener.(*net.UnixListener); isUnix {
+			unixListeners = append(unixListeners, unixListener)
+		}
+	}
+	handover.mutex.Unlock()
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	if err != nil {
+		readyWrite.Close()
+		return err
+	}
+
+	command := exec.Command(executable, os.Args[1:]...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	command.ExtraFiles = append(files, readyWrite)
+	command.Env = append(os.Environ(), listenersEnv+"="+strings.Join(names, ","), readyEnv+"="+strconv.Itoa(3+len(files)))
+	if p.tempDir != "" {
+		command.Env = append(command.Env, cacheDirEnv+"="+p.tempDir)
+	}
+	err = command.Start()
+	readyWrite.Close()
+	if err != nil {
+		return err
+	}
+
+	ready := make(chan bool, 1)
+	go func() {
+		// the pipe is closed without a byte if the new process exits first
+		n, _ := readyRead.Read(make([]byte, 1))
+		ready <- n == 1
+	}()
+	select {
+	case isReady := <-ready:
+		if isReady {
+			log.Println("Re-executed the host as process", command.Process.Pid)
+			for _, unixListener := range unixListeners {
+				// the socket file belongs to the new process now
+				unixListener.SetUnlinkOnClose(false)
+			}
+			if p.tempDir != "" {
+				atomic.StoreInt32(&p.handedOver, 1)
+			}
+			go command.Wait()
+			return nil
+		}
+		err = errors.New("the new process exited before being ready")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	command.Process.Kill()
+	command.Wait()
+	return err
+}
+
+// inheritedCacheDir returns the temporary build cache handed over by the process that re-executed this one, if any
+func inheritedCacheDir() string {
+
+	dir := os.Getenv(cacheDirEnv)
+	os.Unsetenv(cacheDirEnv)
+	if dir == "" {
+		return ""
+	}
+	if _, err := ioutil.ReadDir(dir); err != nil {
+		return ""
+	}
+	return dir
+}
+
+/*
+reexec re-executes the host when the growth policy is exceeded, then calls the drain function given by WithReexec. If it
+cannot, a restart is recommended again by the next load that exceeds the growth policy.
+*/
+func (p *Pluginator) reexec(name string) {
+
+	ctx, cancel := context.WithTimeout(p.ctx, reexecTimeout)
+	defer cancel()
+	if err := p.Reexec(ctx); err != nil {
+		p.growth.mutex.Lock()
+		p.growth.recommended = false
+		p.growth.mutex.Unlock()
+		p.notifyError(name, p.plugins.active(name), &ReexecError{Err: err})
+		return
+	}
+	p.drain()
+}
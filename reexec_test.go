@@ -0,0 +1,170 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// reexecTestEnv makes the test binary, re-executed by TestReexec, play the new host instead of running tests
+const reexecTestEnv = "PLUGINATOR_TEST_REEXEC"
+
+func init() {
+
+	switch os.Getenv(reexecTestEnv) {
+	case "":
+		return
+	case "fail":
+		os.Exit(1)
+	case "ready":
+		if !closeOnExec(3) {
+			os.Exit(7)
+		}
+		if err := Ready(); err != nil {
+			os.Exit(5)
+		}
+		os.Exit(0)
+	}
+	if !closeOnExec(3) || !closeOnExec(4) {
+		os.Exit(7)
+	}
+	listener, err := Listen("test", "tcp", "127.0.0.1:0")
+	if err != nil {
+		os.Exit(2)
+	}
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		os.Exit(3)
+	}
+	defer os.RemoveAll(tempPluginDir)
+	pluginator, err := NewPluginatorF(tempPluginDir)
+	if err != nil {
+		os.Exit(4)
+	}
+	if err = Ready(); err != nil {
+		os.Exit(5)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		os.Exit(6)
+	}
+	conn.Write([]byte(pluginator.cacheDir + "\n"))
+	conn.Close()
+	os.Exit(0)
+}
+
+// closeOnExec tells whether fd is closed in the processes this one executes
+func closeOnExec(fd int) bool {
+
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFD, 0)
+	return errno == 0 && flags&syscall.FD_CLOEXEC != 0
+}
+
+func TestReexec(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+	pluginator, err := NewPluginatorF(tempPluginDir, WithTempDirCleanup())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pluginator.tempDir)
+	listener, err := Listen("test", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := tempPluginDir + "/test.sock"
+	unixListener, err := Listen("unix", "unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(reexecTestEnv, "fail")
+	if err = pluginator.Reexec(context.Background()); err == nil {
+		t.Fatal("Should not be able to re-execute the host if the new process does not get ready")
+	}
+	unixListener.Close()
+	if _, err = os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatal("Should be able to remove the socket of a unix listener not handed over")
+	}
+
+	t.Setenv(reexecTestEnv, "new")
+	if err = pluginator.Reexec(context.Background()); err != nil {
+		t.Fatal("Should be able to re-execute the host, got ", err)
+	}
+	// drain
+	listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal("Should be able to connect to the listener handed over")
+	}
+	defer conn.Close()
+	cacheDir, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || cacheDir != pluginator.tempDir+"\n" {
+		t.Fatal("Should be able to hand the build cache over to the new process, got ", cacheDir)
+	}
+	t.Setenv(reexecTestEnv, "ready")
+	if err = pluginator.Reexec(context.Background()); err != nil {
+		t.Fatal("Should be able to re-execute the host again once its listeners are closed, got ", err)
+	}
+
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(pluginator.tempDir); err != nil {
+		t.Fatal("Should not be able to clean up a build cache handed over")
+	}
+}
+
+func TestReexecRetry(t *testing.T) {
+
+	t.Setenv(reexecTestEnv, "fail")
+	source := newFakeSource(SourcePlugin{Name: "greeter", Code: "Greeting=hello\n"})
+	pluginator, err := NewPluginator(source, WithBuilder(fakeBuilder{}), WithLoader(fakeLoader{}),
+		WithGrowthPolicy(GrowthPolicy{MaxVersions: 1}), WithReexec(func() {
+			t.Error("Should not be able to drain the host if it cannot re-execute")
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, events); event.Kind != EventScan {
+		t.Fatal("Should be able to receive a scan event")
+	}
+
+	for _, name := range []string{"namer", "counter"} {
+		source.changes <- SourceEvent{Action: SourceAdd, Plugin: SourcePlugin{Name: name, Code: "Name=" + name + "\n"}}
+		if event := nextEvent(t, events); event.Kind != EventAdd || event.Name != name {
+			t.Fatal("Should be able to receive an add event")
+		}
+		if event := nextEvent(t, events); event.Kind != EventRestartRecommended || event.Name != name {
+			t.Fatal("Should be able to recommend a restart again after a failed re-execution")
+		}
+		var reexecErr *ReexecError
+		if event := nextEvent(t, events); event.Kind != EventError || !errors.As(event.Err, &reexecErr) {
+			t.Fatal("Should be able to receive a re-execution error")
+		}
+	}
+
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
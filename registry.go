@@ -0,0 +1,167 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Failure is the last attempt at updating a plugin that failed. The previous version, if any, stays active.
+type Failure struct {
+	Code  string
+	Files map[string]string
+	Err   error
+	Time  time.Time
+}
+
+// registry holds the plugins known to a Pluginator. It is written by the delivery goroutine and read by clients.
+type registry struct {
+	mutex   sync.RWMutex
+	entries map[string]*pluginEntry
+}
+
+// pluginEntry is a plugin in the registry: the version in service, if any, and the last attempt at replacing it that failed
+type pluginEntry struct {
+	active  *PluginContent
+	failure *Failure
+}
+
+func newRegistry() *registry {
+	return &registry{
+		entries: make(map[string]*pluginEntry),
+	}
+}
+
+func (r *registry) active(name string) *PluginContent {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if entry, exists := r.entries[name]; exists {
+		return entry.active
+	}
+	return nil
+}
+
+// activate makes pc the active version of plugin name, clearing any failure, and returns the version it replaces
+func (r *registry) activate(name string, pc *PluginContent) *PluginContent {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var previous *PluginContent
+	if entry, exists := r.entries[name]; exists {
+		previous = entry.active
+	}
+	r.entries[name] = &pluginEntry{active: pc}
+	return previous
+}
+
+// fail records a failed attempt at updating plugin name, and returns the version that stays active
+func (r *registry) fail(name string, sp SourcePlugin, err error) *PluginContent {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry, exists := r.entries[name]
+	if !exists {
+		entry = &pluginEntry{}
+		r.entries[name] = entry
+	}
+	entry.failure = &Failure{
+		Code:  sp.Code,
+		Files: sp.Files,
+		Err:   err,
+		Time:  time.Now(),
+	}
+	return entry.active
+}
+
+// remove removes plugin name, and returns its active version
+func (r *registry) remove(name string) *PluginContent {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	entry, exists := r.entries[name]
+	if !exists {
+		return nil
+	}
+	delete(r.entries, name)
+	return entry.active
+}
+
+func (r *registry) snapshot() map[string]*PluginContent {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	active := make(map[string]*PluginContent, len(r.entries))
+	for name, entry := range r.entries {
+		if entry.active != nil {
+			active[name] = entry.active
+		}
+	}
+	return active
+}
+
+// Get returns the active version of plugin name
+func (p *Pluginator) Get(name string) (*PluginContent, bool) {
+
+	pc := p.plugins.active(name)
+	return pc, pc != nil
+}
+
+// List returns the names of the active plugins, sorted
+func (p *Pluginator) List() []string {
+
+	p.plugins.mutex.RLock()
+	defer p.plugins.mutex.RUnlock()
+	var names []string
+	for name, entry := range p.plugins.entries {
+		if entry.active != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+Snapshot returns a copy of the registry, mapping the names of the active plugins to copies of their content. Later changes
+to the registry do not affect it, and changes to it do not affect the registry. The Lib of copies is shared.
+*/
+func (p *Pluginator) Snapshot() map[string]*PluginContent {
+
+	snapshot := p.plugins.snapshot()
+	for name, pc := range snapshot {
+		snapshot[name] = pc.clone()
+	}
+	return snapshot
+}
+
+// clone returns a deep copy of pc, but for Lib, which is shared
+func (pc *PluginContent) clone() *PluginContent {
+
+	c := &PluginContent{
+		Lib:  pc.Lib,
+		Code: pc.Code,
+		API:  pc.API.clone(),
+	}
+	if pc.Files != nil {
+		c.Files = make(map[string]string, len(pc.Files))
+		for path, content := range pc.Files {
+			c.Files[path] = content
+		}
+	}
+	return c
+}
+
+// LastFailure returns the last failed attempt at updating plugin name, or nil if its latest version is active
+func (p *Pluginator) LastFailure(name string) *Failure {
+
+	p.plugins.mutex.RLock()
+	defer p.plugins.mutex.RUnlock()
+	if entry, exists := p.plugins.entries[name]; exists {
+		return entry.failure
+	}
+	return nil
+}
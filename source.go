@@ -0,0 +1,72 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+)
+
+// Source is an origin of plugin source code. Pluginator calls List once when it starts, then consumes Changes until
+// the channel is closed. Close must stop the source and close the Changes channel.
+type Source interface {
+	// List returns the plugins currently available in the source
+	List() ([]SourcePlugin, error)
+	// Changes streams plugin adds, updates and removals
+	Changes() <-chan SourceEvent
+	// Close stops the source
+	Close() error
+}
+
+// SourcePlugin is a plugin in source code form, as found in a Source
+type SourcePlugin struct {
+	// Name is the name the plugin is registered with, e.g. plugin1 for plugin1.go
+	Name string
+	// Code is the source of a single-file plugin
+	Code string
+	// Files are the files of a plugin package, keyed by slash-separated path relative to the package. Code is ignored
+	// when Files is set.
+	Files map[string]string
+	// Origin tells where the plugin comes from, e.g. its file, directory or consul key. Compile diagnostics refer to it.
+	Origin string
+}
+
+// fingerprint is a hash of the plugin's code
+func (sp SourcePlugin) fingerprint() string {
+
+	h := sha256.New()
+	if sp.Files == nil {
+		h.Write([]byte(sp.Code))
+	} else {
+		paths := make([]string, 0, len(sp.Files))
+		for path := range sp.Files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			h.Write([]byte(path))
+			h.Write([]byte{0})
+			h.Write([]byte(sp.Files[path]))
+			h.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SourceEvent is sent by a Source when one of its plugins changes. Code is empty for removals.
+type SourceEvent struct {
+	Action SourceAction
+	Plugin SourcePlugin
+}
+
+// SourceAction is the kind of change described by a SourceEvent
+type SourceAction string
+
+// Source actions
+const (
+	SourceAdd    SourceAction = "Add"
+	SourceRemove SourceAction = "Remove"
+	SourceUpdate SourceAction = "Update"
+)
@@ -0,0 +1,289 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+package pluginator
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSource is a Source whose plugins and changes are set by tests
+type fakeSource struct {
+	plugins   []SourcePlugin
+	changes   chan SourceEvent
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newFakeSource(plugins ...SourcePlugin) *fakeSource {
+	return &fakeSource{
+		plugins: plugins,
+		changes: make(chan SourceEvent),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (fs *fakeSource) String() string {
+	return "fake source"
+}
+
+func (fs *fakeSource) List() ([]SourcePlugin, error) {
+	return fs.plugins, nil
+}
+
+func (fs *fakeSource) Changes() <-chan SourceEvent {
+	return fs.changes
+}
+
+func (fs *fakeSource) Close() error {
+	fs.closeOnce.Do(func() {
+		close(fs.closed)
+		close(fs.changes)
+	})
+	return nil
+}
+
+// nextChange returns the next change of source, skipping those not about plugin name
+func nextChange(t *testing.T, source Source, name string) SourceEvent {
+	for {
+		select {
+		case change, ok := <-source.Changes():
+			if !ok {
+				t.Fatal("Should be able to receive a change")
+			}
+			if change.Plugin.Name == name {
+				return change
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Should be able to receive a change in time")
+		}
+	}
+}
+
+// closedChanges tells whether the Changes channel of source is closed, draining it
+func closedChanges(source Source) bool {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case _, ok := <-source.Changes():
+			if !ok {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func TestCustomSource(t *testing.T) {
+
+	source := newFakeSource(SourcePlugin{Name: "greeter", Code: "Greeting=hello\n", Origin: "fake/greeter"})
+	pluginator, err := NewPluginator(source, WithBuilder(fakeBuilder{}), WithLoader(fakeLoader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := nextEvent(t, events)
+	if event.Kind != EventScan || len(event.Plugins) != 1 || event.Plugins["greeter"] == nil {
+		t.Fatal("Should be able to list the plugins of a custom source")
+	}
+	source.changes <- SourceEvent{Action: SourceAdd, Plugin: SourcePlugin{Name: "namer", Code: "Name=pluginator\n"}}
+	event = nextEvent(t, events)
+	if event.Kind != EventAdd || event.Name != "namer" {
+		t.Fatal("Should be able to receive the changes of a custom source")
+	}
+	source.changes <- SourceEvent{Action: SourceRemove, Plugin: SourcePlugin{Name: "greeter"}}
+	event = nextEvent(t, events)
+	if event.Kind != EventRemove || event.Name != "greeter" || event.Old == nil {
+		t.Fatal("Should be able to receive the removals of a custom source")
+	}
+
+	err = pluginator.Terminate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-source.closed:
+	default:
+		t.Fatal("Should be able to close the source on termination")
+	}
+}
+
+func TestDirSource(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = createTestFile(tempPluginDir+"/single.go", "package main\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(tempPluginDir+"/multi/.hidden", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = createTestFile(tempPluginDir+"/multi/multi.go", "package main\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = createTestFile(tempPluginDir+"/multi/.hidden/hidden.go", "package main\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(tempPluginDir+"/empty", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = createTestFile(tempPluginDir+"/README.md", "not a plugin\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewDirSource(tempPluginDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugins, err := source.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+	if len(plugins) != 2 || plugins[0].Name != "multi" || plugins[1].Name != "single" {
+		t.Fatal("Should be able to list the plugins of a directory, got ", plugins)
+	}
+	if len(plugins[0].Files) != 1 || plugins[0].Files["multi.go"] != "package main\n" || plugins[0].Origin != tempPluginDir+"/multi" {
+		t.Fatal("Should be able to list the files of a plugin package, skipping hidden ones")
+	}
+	if plugins[1].Code != "package main\n" || plugins[1].Origin != tempPluginDir+"/single.go" {
+		t.Fatal("Should be able to list a single-file plugin")
+	}
+
+	err = createTestFile(tempPluginDir+"/added.go", "package main\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	change := nextChange(t, source, "added")
+	if change.Action != SourceAdd || change.Plugin.Code != "package main\n" {
+		t.Fatal("Should be able to detect an added plugin")
+	}
+	err = createTestFile(tempPluginDir+"/multi/other.go", "package main\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	change = nextChange(t, source, "multi")
+	if change.Action != SourceUpdate {
+		t.Fatal("Should be able to detect a change to a plugin package")
+	}
+	err = deleteTestFile(tempPluginDir + "/single.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	change = nextChange(t, source, "single")
+	if change.Action != SourceRemove {
+		t.Fatal("Should be able to detect a removed plugin")
+	}
+
+	err = source.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !closedChanges(source) {
+		t.Fatal("Should be able to close the changes of a closed source")
+	}
+	if err = source.Close(); err != nil {
+		t.Fatal("Should be able to close a source more than once")
+	}
+}
+
+func TestConsulSource(t *testing.T) {
+
+	kv := newFakeKV()
+	server := httptest.NewServer(kv)
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, portS, err := net.SplitHostPort(serverURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv.put("plugins.listed.go", "package main\n")
+	kv.put("plugins.notaplugin", "package main\n")
+	source, err := NewConsulSource(host, port, "plugins", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugins, err := source.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugins) != 1 || plugins[0].Name != "listed" || plugins[0].Code != "package main\n" || plugins[0].Origin != "plugins.listed.go" {
+		t.Fatal("Should be able to list the plugins under a consul prefix, got ", plugins)
+	}
+
+	kv.put("plugins.added.go", "package main\n")
+	change := nextChange(t, source, "added")
+	if change.Action != SourceAdd || change.Plugin.Code != "package main\n" {
+		t.Fatal("Should be able to detect an added plugin")
+	}
+	kv.put("plugins.added.go", "package main\n\n")
+	change = nextChange(t, source, "added")
+	if change.Action != SourceUpdate || change.Plugin.Code != "package main\n\n" {
+		t.Fatal("Should be able to detect an updated plugin")
+	}
+	kv.delete("plugins.added.go")
+	change = nextChange(t, source, "added")
+	if change.Action != SourceRemove || change.Plugin.Code != "" {
+		t.Fatal("Should be able to detect a removed plugin")
+	}
+
+	err = source.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !closedChanges(source) {
+		t.Fatal("Should be able to close the changes of a closed source")
+	}
+
+	applied := 0
+	counting := func(p *Pluginator) {
+		applied++
+	}
+	pluginator, err := NewPluginatorC(host, port, "plugins", WithConsulPollInterval(time.Hour), counting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+	if pluginator.source.(*consulSource).watcher.pollInterval != time.Hour {
+		t.Fatal("Should be able to set the poll interval of the consul source of NewPluginatorC")
+	}
+	if applied != 1 {
+		t.Fatal("Should be able to apply the options of NewPluginatorC once, applied ", applied, " times")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// langVersionPattern matches the language version of a go version, like go1.21.3, go1.22rc1 or devel go1.23-abcdef
+var langVersionPattern = regexp.MustCompile(`go(\d+\.\d+)`)
+
+// hostFlags are the build settings of the host that change the code of every package, and must be used for plugins too
+var hostFlags = map[string]bool{
+	"-asan":     true,
+	"-msan":     true,
+	"-race":     true,
+	"-trimpath": true,
+}
+
+// hostValueFlags are like hostFlags, but have a value
+var hostValueFlags = map[string]bool{
+	"-asmflags": true,
+	"-gcflags":  true,
+	"-tags":     true,
+}
+
+// hostEnv are the build settings of the host that come from the environment, and must be used for plugins too
+var hostEnv = map[string]bool{
+	"GO386":        true,
+	"GOAMD64":      true,
+	"GOARM":        true,
+	"GOARM64":      true,
+	"GOEXPERIMENT": true,
+	"GOMIPS":       true,
+	"GOMIPS64":     true,
+	"GOPPC64":      true,
+	"GORISCV64":    true,
+	"GOWASM":       true,
+}
+
+/*
+probeToolchain checks that the go tool builds plugins the running host can load: same go version, OS and architecture,
+with cgo. The host's build settings, like -race or GOAMD64, are added to the build flags and environment of plugins.
+*/
+func (p *Pluginator) probeToolchain() error {
+
+	command := p.goCommand(p.buildCtx, "env", "-json")
+	var stdErr bytes.Buffer
+	command.Stderr = &stdErr
+	out, err := command.Output()
+	if err != nil {
+		if stdErr.Len() == 0 {
+			return err
+		}
+		return errors.New("cannot run " + p.goBinary + ": " + strings.TrimSpace(stdErr.String()))
+	}
+	var goEnv map[string]string
+	if err = json.Unmarshal(out, &goEnv); err != nil {
+		return err
+	}
+	if goEnv["GOVERSION"] == "" {
+		// go env reports GOVERSION since go 1.16
+		goEnv["GOVERSION"], err = p.toolchainVersion()
+		if err != nil {
+			return err
+		}
+	}
+
+	var mismatches []string
+	compare := func(name, toolchainValue, hostValue string) {
+		if toolchainValue != hostValue {
+			mismatches = append(mismatches, name+" is "+toolchainValue+", the host's "+hostValue)
+		}
+	}
+	compare("GOVERSION", goEnv["GOVERSION"], runtime.Version())
+	compare("GOOS", goEnv["GOOS"], runtime.GOOS)
+	compare("GOARCH", goEnv["GOARCH"], runtime.GOARCH)
+	if goEnv["CGO_ENABLED"] != "1" {
+		mismatches = append(mismatches, "CGO_ENABLED is "+goEnv["CGO_ENABLED"]+", plugins need cgo")
+	}
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range buildInfo.Settings {
+			switch {
+			case hostFlags[setting.Key] && setting.Value == "true":
+				p.buildFlags = append(p.buildFlags, setting.Key)
+			case hostValueFlags[setting.Key]:
+				p.buildFlags = append(p.buildFlags, setting.Key+"="+setting.Value)
+			case hostEnv[setting.Key] && goEnv[setting.Key] != setting.Value:
+				p.buildEnv = append(p.buildEnv, setting.Key+"="+setting.Value)
+			case setting.Key == "CGO_ENABLED" && setting.Value != "1":
+				mismatches = append(mismatches, "the host was built without cgo, and cannot load plugins")
+			}
+		}
+	}
+	if len(mismatches) > 0 {
+		return errors.New("go toolchain " + p.goBinary + " cannot build plugins for this host: " + strings.Join(mismatches, "; "))
+	}
+	p.goVersion = goEnv["GOVERSION"] + " " + goEnv["GOOS"] + "/" + goEnv["GOARCH"]
+	p.goLang = langVersion(goEnv["GOVERSION"])
+	return nil
+}
+
+// langVersion returns the language version of a go version, e.g. 1.21 for go1.21.3 or go1.22rc1, or "" if it has none
+func langVersion(goVersion string) string {
+
+	match := langVersionPattern.FindStringSubmatch(goVersion)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// toolchainVersion returns the version of the go tool from the output of go version, e.g. go1.15.2
+func (p *Pluginator) toolchainVersion() (string, error) {
+
+	out, err := p.goCommand(p.buildCtx, "version").Output()
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 4 || fields[0] != "go" || fields[1] != "version" {
+		return "", errors.New("cannot parse output from go version: " + string(out))
+	}
+	return fields[2], nil
+}
@@ -0,0 +1,58 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestToolchain(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	goRoot, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pluginator, err := NewPluginatorF(tempPluginDir, WithGoRoot(strings.TrimSpace(string(goRoot))))
+	if err != nil {
+		t.Fatal("Should be able to use the toolchain the host was built with, got ", err)
+	}
+	defer pluginator.Terminate(context.Background())
+	if !strings.HasPrefix(pluginator.goVersion, runtime.Version()+" ") || !strings.HasPrefix(runtime.Version(), "go"+pluginator.goLang) {
+		t.Fatal("Should be able to probe the toolchain version")
+	}
+	for goVersion, lang := range map[string]string{"go1.21.3": "1.21", "go1.22rc1": "1.22", "devel go1.23-abcdef": "1.23", "unknown": ""} {
+		if langVersion(goVersion) != lang {
+			t.Fatal("Should be able to tell the language version of ", goVersion)
+		}
+	}
+
+	_, err = NewPluginatorF(tempPluginDir, WithGoBinary(tempPluginDir+"/nogo"))
+	if err == nil {
+		t.Fatal("Should not be able to use a missing go binary")
+	}
+
+	// a go binary reporting another version
+	fakeGo := tempPluginDir + "/go"
+	goEnv := `{"GOVERSION": "go1.8.3", "GOOS": "` + runtime.GOOS + `", "GOARCH": "` + runtime.GOARCH + `", "CGO_ENABLED": "1"}`
+	err = ioutil.WriteFile(fakeGo, []byte("#!/bin/sh\necho '"+goEnv+"'\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewPluginatorF(tempPluginDir, WithGoBinary(fakeGo))
+	if err == nil || !strings.Contains(err.Error(), "GOVERSION is go1.8.3") {
+		t.Fatal("Should be able to detect a toolchain of another version, got ", err)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+Validator decides whether candidate, a new version of plugin name that was loaded but is not active yet, can go live. active
+is the version it would replace, nil if there is none. A non-nil error vetoes the activation, and is the reason given to
+error subscribers.
+*/
+type Validator func(name string, candidate, active *PluginContent) error
+
+// ValidationError is sent to error subscribers when a validator vetoes the activation of a plugin
+type ValidationError struct {
+	Plugin string
+	// Origin is where the vetoed version of the plugin comes from
+	Origin    string
+	Validator string
+	// Err is the reason given by the validator
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+
+	if e.Origin == "" {
+		return "validator " + e.Validator + " rejected " + e.Plugin + ": " + e.Err.Error()
+	}
+	return "validator " + e.Validator + " rejected " + e.Plugin + " from " + e.Origin + ": " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+type namedValidator struct {
+	name      string
+	validator Validator
+}
+
+// validators are the registered validators, in registration order
+type validators struct {
+	mutex sync.RWMutex
+	list  []namedValidator
+}
+
+/*
+AddValidator registers validator under name. Each new version of a plugin goes live only if all validators approve it;
+they are called in the order they were added, on the goroutine delivering events, which waits for them. A validator that
+panics, or does not return within the hook timeout, vetoes the activation; one that times out is left running.
+*/
+func (p *Pluginator) AddValidator(name string, validator Validator) {
+
+	p.validators.mutex.Lock()
+	defer p.validators.mutex.Unlock()
+	p.validators.list = append(p.validators.list, namedValidator{name: name, validator: validator})
+}
+
+// validate returns a *ValidationError if a validator vetoes the activation of candidate, coming from origin
+func (p *Pluginator) validate(name, origin string, candidate, active *PluginContent) error {
+
+	p.validators.mutex.RLock()
+	list := p.validators.list
+	p.validators.mutex.RUnlock()
+	for _, nv := range list {
+		if err := p.callValidator(nv.validator, name, candidate, active); err != nil {
+			return &ValidationError{
+				Plugin:    name,
+				Origin:    origin,
+				Validator: nv.name,
+				Err:       err,
+			}
+		}
+	}
+	return nil
+}
+
+// callValidator calls validator, turning a panic, or not returning within the hook timeout, into a veto
+func (p *Pluginator) callValidator(validator Validator, name string, candidate, active *PluginContent) error {
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- validator(name, candidate, active)
+	}()
+	timer := time.NewTimer(p.hookTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errors.New("timed out after " + p.hookTimeout.String())
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright Piero de Salvia.
+// All Rights Reserved
+
+package pluginator
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidators(t *testing.T) {
+
+	tempPluginDir, err := ioutil.TempDir("", "testplugindir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempPluginDir)
+
+	err = copyTestFile(tempPluginDir+"/plugin1.go", testDataDir+"/plugin1.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginator, err := NewPluginatorF(tempPluginDir, WithHookTimeout(500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	var activeAtValidation *PluginContent
+	pluginator.AddValidator("smoke test", func(name string, candidate, active *PluginContent) error {
+		activeAtValidation = active
+		add, err := candidate.Lib.Lookup("Add")
+		if err != nil {
+			return err
+		}
+		if add.(func(int, int) int)(1, 2) != 3 {
+			return errors.New("1 + 2 is not 3")
+		}
+		return nil
+	})
+	pluginator.AddValidator("panicky", func(name string, candidate, active *PluginContent) error {
+		if strings.Contains(candidate.Code, "panic") {
+			panic("cannot validate")
+		}
+		return nil
+	})
+	pluginator.AddValidator("hanging", func(name string, candidate, active *PluginContent) error {
+		if strings.Contains(candidate.Code, "hang") {
+			<-release
+		}
+		return nil
+	})
+	events := pluginator.Events(context.Background())
+	err = pluginator.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pluginator.Terminate(context.Background())
+
+	event := nextEvent(t, events)
+	if event.Kind != EventScan || event.Plugins["plugin1"] == nil || activeAtValidation != nil {
+		t.Fatal("Should be able to activate a plugin approved by all validators")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", "package main\n\nfunc Add(x, y int) int {\n\treturn x - y\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	ve, ok := event.Err.(*ValidationError)
+	if event.Kind != EventError || !ok || ve.Validator != "smoke test" || ve.Err.Error() != "1 + 2 is not 3" {
+		t.Fatal("Should be able to veto an update, with the validator's reason")
+	}
+	if ve.Origin != tempPluginDir+"/plugin1.go" {
+		t.Fatal("Should be able to tell where a vetoed plugin comes from, got ", ve.Origin)
+	}
+	if activeAtValidation == nil || activeAtValidation != event.Old {
+		t.Fatal("Should be able to pass validators the active version")
+	}
+	if active, _ := pluginator.Get("plugin1"); active != event.Old {
+		t.Fatal("Should be able to keep the active version when an update is vetoed")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", "package main\n\n// no panic here\nfunc Add(x, y int) int {\n\treturn x + y\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	ve, ok = event.Err.(*ValidationError)
+	if event.Kind != EventError || !ok || ve.Validator != "panicky" || !strings.Contains(ve.Error(), "cannot validate") {
+		t.Fatal("Should be able to turn a validator's panic into a veto")
+	}
+
+	err = replaceTestFile(tempPluginDir+"/plugin1.go", "package main\n\n// hang on\nfunc Add(x, y int) int {\n\treturn x + y\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(t, events)
+	ve, ok = event.Err.(*ValidationError)
+	if event.Kind != EventError || !ok || ve.Validator != "hanging" || !strings.HasPrefix(ve.Err.Error(), "timed out") {
+		t.Fatal("Should be able to turn a validator that does not return in time into a veto")
+	}
+}